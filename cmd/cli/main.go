@@ -1,17 +1,40 @@
 package main
 
 import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/b4bay/aspm/internal/cli"
 	"github.com/b4bay/aspm/internal/shared"
+	"io"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var Exit = os.Exit
-var server = os.Getenv("ASPM_SERVER_URL")
-var key = os.Getenv("ASPM_SERVER_KEY")
-var aspmClient cli.ASPMClientInterface = cli.NewASPMClient(server, key)
+var aspmClient cli.ASPMClientInterface // Created from the configuration on first use
+var config cli.Config
+var stdin io.Reader = os.Stdin // Answers of interactive prompts
+
+// Longer origin lists are counted instead of listed, and hashing them reports progress
+const maxListedOrigins = 10
+
+// Uploads of collect and origin are printed or saved instead of sent, see uploadFlags
+var (
+	dryRun  bool
+	outPath string
+	outbox  []cli.Upload
+)
 
 var (
 	DefaultArtefact, _ = os.Getwd()
@@ -43,92 +66,99 @@ func main() {
 		handleGWMode(args)
 	case shared.CliModeOrigin:
 		handleOriginMode(args)
+	case shared.CliModeFlush:
+		handleFlushMode(args)
+	case shared.CliModeConfig:
+		handleConfigMode(args)
+	case shared.CliModeMerge:
+		handleMergeMode(args)
+	case shared.CliModeProducts:
+		handleProductsMode(args)
+	case shared.CliModeFindings:
+		handleFindingsMode(args)
+	case shared.CliModeEngagements:
+		handleEngagementsMode(args)
+	case shared.CliModeShow:
+		handleShowMode(args)
+	case shared.CliModeTriage:
+		handleTriageMode(args)
+	case shared.CliModeGraph:
+		handleGraphMode(args)
+	case shared.CliModeUpload:
+		handleUploadMode(args)
+	case shared.CliModeKeygen:
+		handleKeygenMode(args)
+	case shared.CliModeBlast:
+		handleBlastMode(args)
 	default:
-		fmt.Printf("Error: Unknown mode '%s'. Supported modes are 'origin', 'collect' and 'gw'.\n", mode)
+		fmt.Printf("Error: Unknown mode '%s'. Supported modes are %v.\n", mode, shared.AllowedCliModes)
 		Exit(1)
 	}
 }
 
 func handleCollectMode(args []string) {
-	var artefactType shared.ArtefactType
 	var (
-		artefactPath   string
-		artefactId     string
-		artefactName   string
-		artefactAuthor string
-		artefactInfo   os.FileInfo
-		reportsPath    []string
+		artefactPath string
+		reportsPath  []string
 	)
 
-	var err error
 	var collectPayload shared.CollectMessageBody
 
 	fs := flag.NewFlagSet(string(shared.CliModeCollect), flag.ExitOnError)
+	purl, cpe := identityFlags(fs)
+	uploadFlags(fs)
 	fs.Parse(args)
+	loadConfig(fs)
 
 	unnamed := fs.Args()
-	if len(unnamed) < 2 {
-		fmt.Println("Error: at least artefact and one report required")
-		Exit(1)
-	} else {
+	if len(unnamed) > 0 {
 		artefactPath = unnamed[0]
 		reportsPath = unnamed[1:]
 	}
-
-	// Processing artefact
-	artefactInfo, err = os.Stat(artefactPath)
-	if os.IsNotExist(err) {
-		fmt.Printf("Error: Product not found '%s'", artefactPath)
-		Exit(1)
-	}
-
-	if artefactInfo.IsDir() {
-		artefactType = shared.ArtefactTypeGit
-	} else {
-		artefactType = shared.ArtefactTypeBin
+	if len(reportsPath) == 0 {
+		reportsPath = config.ReportPaths()
 	}
-
-	if artefactType == shared.ArtefactTypeGit {
-		artefactId, err = cli.IdGit(artefactPath)
-		if err != nil {
-			fmt.Printf("Error: Invalid artefact (id) '%s': %v\n", artefactPath, err)
-			Exit(1)
-		}
-		artefactName, err = cli.NameGit(artefactPath)
-		if err != nil {
-			fmt.Printf("Error: Invalid artefact (name) '%s': %v\n", artefactPath, err)
-			Exit(1)
-		}
-		artefactAuthor = cli.GetAuthorFromGit()
+	if artefactPath == "" || len(reportsPath) == 0 {
+		fmt.Println("Error: at least artefact and one report required")
+		Exit(1)
 	}
 
-	if artefactType == shared.ArtefactTypeBin {
-		artefactId, err = cli.IdBin(artefactPath)
-		if err != nil {
-			fmt.Printf("Error: Invalid artefact '%s': %v\n", artefactPath, err)
-			Exit(1)
-		}
+	// Processing artefact
+	artefact, ok := describeArtefact("artefact", artefactPath)
+	if !ok || !identifyArtefact("artefact", &artefact, *purl, *cpe) {
+		return
 	}
 
 	fmt.Printf("Running in 'collect' mode: artefact=%s, reports=%v\n", artefactPath, reportsPath)
 
-	collectPayload.Artefact = shared.ProductMessage{
-		Id:     artefactId,
-		Type:   artefactType,
-		Name:   artefactName,
-		Author: artefactAuthor,
-	}
-	collectPayload.Environment = cli.GetEnvironment()
+	collectPayload.Artefact = artefact
+	collectPayload.Environment = cli.GetEnvironment(config.Environment)
+	collectPayload.CI = ciContext(artefactPath)
 	collectPayload.Reports = cli.GetReports(reportsPath)
+	collectPayload.IdempotencyKey = shared.CollectIdempotencyKey(artefact.Id, collectPayload.Reports)
 
-	aspmClient.Post("/"+string(shared.CliModeCollect), collectPayload)
+	upload("/"+string(shared.CliModeCollect), &collectPayload)
+
+	for _, lineagePayload := range lineage(artefact, artefactPath) {
+		lineagePayload.Environment = collectPayload.Environment
+		lineagePayload.CI = collectPayload.CI
+		upload("/"+string(shared.CliModeOrigin), &lineagePayload)
+	}
 }
 
 func handleGWMode(args []string) {
 	fs := flag.NewFlagSet(string(shared.CliModeGW), flag.ExitOnError)
 	typ := fs.String("type", string(shared.ArtefactTypeDefault), "Type value")
 	scope := fs.String("scope", DefaultScope, "Scope path")
+	fs.String("profile", "", "Configuration profile")
+	fs.String("level", "", "Minimal finding level which breaks the gate (default from configuration)")
+	fs.Int("max", 0, "Number of breaking findings tolerated (default from configuration)")
+	fs.Bool("signed", false, "Require the evidence of the product signed with a registered key (default from configuration)")
+	fs.String("server", "", "ASPM server URL (default from configuration)")
+	baseline := fs.String("baseline", "", "Product id or ref of the target branch, only findings introduced since break the gate")
+	format := fs.String("format", cli.OutputFormatDefault, fmt.Sprintf("Output format %v", cli.OutputFormats))
 	fs.Parse(args)
+	loadConfig(fs)
 
 	if *typ != "" && !shared.IsValidArtefactType(shared.ArtefactType(*typ)) {
 		fmt.Printf("Error: Invalid type '%s'\n", *typ)
@@ -146,152 +176,1313 @@ func handleGWMode(args []string) {
 		artefact = unnamed[0]
 	}
 
-	fmt.Printf("Running in 'gw' mode: type=%s, scope=%s, artefact=%s\n", *typ, *scope, artefact)
+	// JSON output is meant for tools, nothing else goes to stdout then
+	if *format != cli.OutputFormatJSON {
+		fmt.Printf("Running in 'gw' mode: type=%s, scope=%s, artefact=%s, level=%s, max=%d, signed=%t\n", *typ, *scope, artefact, config.Gate.Level, config.Gate.Max, config.Gate.Signed)
+	}
+
+	if config.Gate.Level != "" && !cli.IsValidLevel(config.Gate.Level) {
+		fmt.Printf("Error: Invalid level '%s'. Supported levels are %v.\n", config.Gate.Level, cli.Levels)
+		Exit(1)
+		return
+	}
+	if !isValidFormat(*format) {
+		return
+	}
+
+	// Without a baseline nor a gate policy there is nothing to check, pipelines calling the gate before it was one pass
+	strict := *baseline != "" || config.Gate != (cli.Gate{})
+	fs.Visit(func(f *flag.Flag) { strict = strict || f.Name == "max" })
+	if !strict {
+		if *format != cli.OutputFormatJSON {
+			fmt.Println("No baseline and no gate configured, the gate passes")
+		}
+		return
+	}
+
+	// Same as graph, a path is identified the way collect does it and anything else is taken for a product id.
+	// Relative paths are in the scope, a type given is the one the artefact must have.
+	id := artefact
+	artefactPath := artefact
+	if !filepath.IsAbs(artefactPath) {
+		artefactPath = filepath.Join(*scope, artefactPath)
+	}
+	if _, err := os.Stat(artefactPath); err == nil {
+		product, ok := describeArtefact("artefact", artefactPath)
+		if !ok {
+			return
+		}
+		typed := false
+		fs.Visit(func(f *flag.Flag) { typed = typed || f.Name == "type" })
+		if typed && product.Type != shared.ArtefactType(*typ) {
+			fmt.Printf("Error: Artefact '%s' is of type '%s', not '%s'\n", artefact, product.Type, *typ)
+			Exit(1)
+			return
+		}
+		id = product.Id
+	}
+
+	report, err := cli.CompareFindings(client(), id, *baseline)
+	if err != nil {
+		fmt.Printf("Error: Failed to compare findings: %v\n", err)
+		Exit(1)
+		return
+	}
+
+	if *format == cli.OutputFormatJSON {
+		render(*format, nil, nil, report)
+	} else {
+		if report.BaselineID != "" {
+			fmt.Printf("Baseline %s: introduced=%d, fixed=%d, unchanged=%d\n", report.BaselineID, len(report.Introduced), len(report.Fixed), len(report.Unchanged))
+		} else {
+			fmt.Printf("No baseline: introduced=%d\n", len(report.Introduced))
+		}
+		var rows [][]string
+		for _, finding := range report.Introduced {
+			rows = append(rows, finding.Row())
+		}
+		render(*format, cli.FindingColumns, rows, nil)
+	}
+
+	if breaking := report.Breaking(config.Gate.Level); len(breaking) > config.Gate.Max {
+		fmt.Printf("Gate failed: %d introduced findings break the gate, %d tolerated\n", len(breaking), config.Gate.Max)
+		Exit(1)
+		return
+	}
 
+	// Findings of unsigned reports can't be trusted to be all there is
+	if config.Gate.Signed {
+		if len(report.Evidence) == 0 {
+			fmt.Println("Gate failed: the product has no evidence, signed evidence is required")
+			Exit(1)
+		} else if unsigned := report.Unsigned(); len(unsigned) > 0 {
+			fmt.Printf("Gate failed: %d of %d engagements are not signed, signed evidence is required\n", len(unsigned), len(report.Evidence))
+			Exit(1)
+		}
+	}
 }
 
 func handleOriginMode(args []string) {
-	var err error
 	var productionMethod shared.ProductionMethod
 	var originPayload shared.OriginMessageBody
 	var (
-		productPath         string
-		productId           string
-		productName         string
-		productAuthor       string
-		productInfo         os.FileInfo
-		productArtefactType shared.ArtefactType
-		origins             []shared.ProductMessage
-		originsPath         []string
-		originPath          string
-		originId            string
-		originName          string
-		originAuthor        string
-		originInfo          os.FileInfo
-		originArtefactType  shared.ArtefactType
+		productPath string
+		origins     []shared.ProductMessage
+		originsPath []string
 	)
 
 	fs := flag.NewFlagSet(string(shared.CliModeOrigin), flag.ExitOnError)
-	method := fs.String("method", string(shared.ProductionMethodDefault), "Method (compile or pack)")
+	fs.String("method", "", "Method (compile, pack, containerize, component or strip, default from configuration)")
+	attest := fs.String("attest", "", "Write SLSA provenance of the products to the file, as in-toto JSON lines")
+	exclude := fs.String("exclude", "", "Comma separated patterns of origins to leave out, e.g. '*_test.o,vendor/'")
+	workers := fs.Int("workers", runtime.NumCPU(), "Number of origins hashed at the same time")
+	chunk := fs.Int("chunk", 1000, "Maximal number of origins in one payload, 0 for no limit")
+	purl, cpe := identityFlags(fs)
+	uploadFlags(fs)
 	fs.Parse(args)
+	loadConfig(fs)
+
+	if shared.IsValidProductionMethod(config.Method) {
+		productionMethod = config.Method
+	} else {
+		fmt.Printf("Error: Invalid method '%s'\n", config.Method)
+		Exit(1)
+	}
 
+	// Packed archive is its own list of origins
 	unnamed := fs.Args()
-	if len(unnamed) < 2 {
+	isPackedArchive := len(unnamed) > 0 && productionMethod == shared.ProductionMethodPack && cli.IsArchive(unnamed[0])
+	// Go binaries know their origins from the build info
+	isGoBinary := len(unnamed) > 0 && cli.IsGoBinary(unnamed[0])
+	// Stripped binaries point to their debug build
+	isStripped := len(unnamed) > 0 && productionMethod == shared.ProductionMethodStrip && cli.IsELF(unnamed[0])
+	if len(unnamed) < 2 && !isPackedArchive && !isGoBinary && !isStripped {
 		fmt.Println("Error: at least artefact and one origin required")
 		Exit(1)
+		return
 	} else {
 		productPath = unnamed[0]
-		originsPath = unnamed[1:]
 	}
 
-	if shared.IsValidProductionMethod(shared.ProductionMethod(*method)) {
-		productionMethod = shared.ProductionMethod(*method)
-	} else {
-		fmt.Printf("Error: Invalid method '%s'", *method)
+	// Origins may be globs and @file lists, too many for the command line otherwise
+	originsPath, err := cli.ExpandOrigins(unnamed[1:], splitList(*exclude))
+	if err != nil {
+		fmt.Printf("Error: Invalid origins: %v\n", err)
 		Exit(1)
+		return
 	}
 
-	fmt.Printf("Running in 'origin' mode: method=%s, product=%s, origins=%v\n", *method, productPath, originsPath)
+	if productionMethod == shared.ProductionMethodStrip {
+		if len(originsPath) == 0 {
+			debugPath, err := cli.FindDebugFile(productPath)
+			if err != nil {
+				fmt.Printf("Error: Invalid product (debug file) '%s': %v\n", productPath, err)
+				Exit(1)
+				return
+			}
+			fmt.Printf("Found debug file '%s'\n", debugPath)
+			originsPath = []string{debugPath}
+		}
+		for _, originPath := range originsPath {
+			if err := cli.MatchDebugFile(productPath, originPath); err != nil {
+				fmt.Printf("Error: Invalid origin (debug file) '%s': %v\n", originPath, err)
+				Exit(1)
+				return
+			}
+		}
+	}
 
-	// Processing Product
-	productInfo, err = os.Stat(productPath)
-	if os.IsNotExist(err) {
-		fmt.Printf("Error: Product not found '%s'", productPath)
-		Exit(1)
+	if len(originsPath) > maxListedOrigins {
+		fmt.Printf("Running in 'origin' mode: method=%s, product=%s, origins=%d\n", productionMethod, productPath, len(originsPath))
+	} else {
+		fmt.Printf("Running in 'origin' mode: method=%s, product=%s, origins=%v\n", productionMethod, productPath, originsPath)
 	}
 
-	if productInfo.IsDir() {
-		productArtefactType = shared.ArtefactTypeGit
+	// Processing Product, an image being containerized is read with its layers once
+	var image *cli.Image
+	if productionMethod == shared.ProductionMethodContainerize {
+		var err error
+		if image, err = cli.ReadImage(productPath, true); err != nil && !errors.Is(err, cli.ErrNotImage) {
+			fmt.Printf("Error: Invalid product (image) '%s': %v\n", productPath, err)
+			Exit(1)
+			return
+		}
+	}
+	product, ok := shared.ProductMessage{}, true
+	if image != nil {
+		product = image.ProductMessage()
 	} else {
-		productArtefactType = shared.ArtefactTypeBin
+		product, ok = describeArtefact("product", productPath)
+	}
+	if !ok || !identifyArtefact("product", &product, *purl, *cpe) {
+		return
 	}
 
-	if productArtefactType == shared.ArtefactTypeGit {
-		productId, err = cli.IdGit(productPath)
-		if err != nil {
-			fmt.Printf("Error: Invalid product (id) '%s': %v\n", productPath, err)
+	// Processing Origins, hashed in parallel but kept in the order given
+	lineagePayloads := lineage(product, productPath)
+	// Workers only read, errors and warnings are reported here once all are done
+	described := make([]shared.ProductMessage, len(originsPath))
+	describedErr := make([]error, len(originsPath))
+	lineages := make([][]shared.OriginMessageBody, len(originsPath))
+	step := max(len(originsPath)/10, 1)
+	cli.ForEach(len(originsPath), *workers, func(i int) {
+		described[i], describedErr[i] = readArtefact("origin", originsPath[i])
+		if describedErr[i] == nil {
+			lineages[i] = lineage(described[i], originsPath[i])
+		}
+	}, func(completed int) {
+		if len(originsPath) > maxListedOrigins && (completed%step == 0 || completed == len(originsPath)) {
+			fmt.Printf("Hashed %d/%d origins\n", completed, len(originsPath))
+		}
+	})
+	for i := range originsPath {
+		if describedErr[i] != nil {
+			fmt.Printf("Error: %v\n", describedErr[i])
 			Exit(1)
+			return
 		}
-		productName, err = cli.NameGit(productPath)
+		warnArtefact("origin", originsPath[i], described[i])
+		origins = append(origins, described[i])
+		lineagePayloads = append(lineagePayloads, lineages[i]...)
+	}
+
+	if isPackedArchive {
+		members, err := cli.ArchiveMembers(productPath)
 		if err != nil {
-			fmt.Printf("Error: Invalid product (name) '%s': %v\n", productPath, err)
+			fmt.Printf("Error: Invalid product (archive) '%s': %v\n", productPath, err)
 			Exit(1)
+			return
+		}
+		for _, member := range members {
+			origins = append(origins, shared.ProductMessage{
+				Id:      member.Id,
+				Name:    path.Base(member.Path),
+				Type:    shared.ArtefactTypeBin,
+				Digests: member.Digests,
+			})
+		}
+		fmt.Printf("Found %d members in archive '%s'\n", len(members), productPath)
+	}
+
+	// Base image and binaries are known from the image itself
+	if image != nil {
+		if image.BaseDigest != "" {
+			origins = append(origins, shared.ProductMessage{
+				Id:   image.BaseDigest,
+				Name: image.BaseName,
+				Type: shared.ArtefactTypeImage,
+			})
+		}
+		for _, binary := range image.Binaries {
+			origins = append(origins, shared.ProductMessage{
+				Id:      binary.Id,
+				Name:    path.Base(binary.Path),
+				Type:    shared.ArtefactTypeBin,
+				Digests: binary.Digests,
+			})
+		}
+		fmt.Printf("Found %d binaries in image '%s'\n", len(image.Binaries), productPath)
+	}
+
+	originPayload.Product = product
+	originPayload.Origins = origins
+	originPayload.ProductionMethod = productionMethod
+	originPayload.Environment = cli.GetEnvironment(config.Environment)
+	originPayload.CI = ciContext(productPath)
+
+	if *attest != "" {
+		var attested []shared.OriginMessageBody
+		if len(origins) > 0 {
+			attested = append(attested, originPayload)
+		}
+		for _, lineagePayload := range lineagePayloads {
+			lineagePayload.CI = originPayload.CI
+			attested = append(attested, lineagePayload)
 		}
-		productAuthor = cli.GetAuthorFromGit()
+		if !writeAttestations(*attest, attested) {
+			return
+		}
+	}
+
+	// Huge origin lists are sent in chunks, the server adds the links of every chunk to the product
+	size := *chunk
+	if size <= 0 {
+		size = len(origins)
+	}
+	for start := 0; start < len(origins); start += size {
+		chunkPayload := originPayload
+		chunkPayload.Origins = origins[start:min(start+size, len(origins))]
+		upload("/"+string(shared.CliModeOrigin), &chunkPayload)
+	}
+
+	for _, lineagePayload := range lineagePayloads {
+		lineagePayload.Environment = originPayload.Environment
+		lineagePayload.CI = originPayload.CI
+		upload("/"+string(shared.CliModeOrigin), &lineagePayload)
+	}
+}
+
+func handleFlushMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeFlush), flag.ExitOnError)
+	fs.String("profile", "", "Configuration profile")
+	fs.String("server", "", "ASPM server URL (default from configuration)")
+	fs.String("spool", "", "Spool directory for failed uploads (default from configuration)")
+	fs.Parse(args)
+	loadConfig(fs)
+
+	if len(fs.Args()) > 0 {
+		fmt.Println("Error: flush mode takes no arguments")
+		Exit(1)
+		return
+	}
+
+	entries, err := cli.SpoolEntries(config.Spool)
+	if err != nil {
+		fmt.Printf("Error: Failed to read spool '%s': %v\n", config.Spool, err)
+		Exit(1)
+		return
 	}
 
-	if productArtefactType == shared.ArtefactTypeBin {
-		productId, err = cli.IdBin(productPath)
+	fmt.Printf("Running in 'flush' mode: spool=%s, entries=%d\n", config.Spool, len(entries))
+
+	// Entries are replayed in order and flushing stops at the first failure,
+	// so the server never receives a later upload before an earlier one
+	for _, path := range entries {
+		entry, err := cli.ReadSpoolEntry(path)
 		if err != nil {
-			fmt.Printf("Error: Invalid product (id) '%s': %v\n", productPath, err)
+			fmt.Printf("Error: Invalid spool entry '%s': %v\n", path, err)
+			Exit(1)
+			return
+		}
+
+		if err = client().Post(entry.Endpoint, entry.Payload); err != nil {
+			fmt.Printf("Error: Failed to upload spool entry '%s': %v\n", path, err)
+			Exit(1)
+			return
+		}
+
+		if err = os.Remove(path); err != nil {
+			fmt.Printf("Error: Failed to remove spool entry '%s': %v\n", path, err)
 			Exit(1)
+			return
+		}
+		fmt.Printf("Uploaded spool entry '%s'\n", path)
+	}
+}
+
+func handleConfigMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeConfig), flag.ExitOnError)
+	uploadFlags(fs)
+	fs.String("method", "", "Method (compile, pack, containerize, component or strip, default from configuration)")
+	fs.String("level", "", "Minimal finding level which breaks the gate (default from configuration)")
+	fs.Int("max", 0, "Number of breaking findings tolerated (default from configuration)")
+	fs.Bool("signed", false, "Require the evidence of the product signed with a registered key (default from configuration)")
+	fs.Parse(args)
+	loadConfig(fs)
+
+	fmt.Print(config.String())
+}
+
+// handleMergeMode asks the server to merge a product into another one, the server key must be the admin key
+func handleMergeMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeMerge), flag.ExitOnError)
+	fs.String("profile", "", "Configuration profile")
+	fs.String("server", "", "ASPM server URL (default from configuration)")
+	fs.Parse(args)
+	loadConfig(fs)
+
+	if len(fs.Args()) != 2 {
+		fmt.Println("Error: merge mode takes the product to merge and the product to keep")
+		Exit(1)
+		return
+	}
+
+	mergePayload := shared.MergeMessageBody{From: fs.Arg(0), Into: fs.Arg(1)}
+	fmt.Printf("Running in 'merge' mode: from=%s, into=%s\n", mergePayload.From, mergePayload.Into)
+
+	// Not spooled, merging is an interactive administrative operation
+	if err := client().Post("/admin/merge", mergePayload); err != nil {
+		fmt.Printf("Error: Failed to merge products: %v\n", err)
+		Exit(1)
+		return
+	}
+}
+
+func handleProductsMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeProducts), flag.ExitOnError)
+	format := queryFlags(fs)
+	filters := listFlags(fs)
+	filters["project"] = fs.String("project", "", "Products of the project")
+	filters["type"] = fs.String("type", "", fmt.Sprintf("Products of the type %v", shared.AllowedArtefactTypes))
+	fs.Parse(args)
+	loadConfig(fs)
+	if !isValidFormat(*format) {
+		return
+	}
+
+	products, err := cli.Products(client(), listQuery(filters))
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch products: %v\n", err)
+		Exit(1)
+		return
+	}
+
+	var rows [][]string
+	for _, product := range products {
+		rows = append(rows, product.Row())
+	}
+	render(*format, cli.ProductColumns, rows, products)
+}
+
+func handleFindingsMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeFindings), flag.ExitOnError)
+	format := queryFlags(fs)
+	filters := listFlags(fs)
+	filters["product"] = fs.String("product", "", "Findings of the product, its id or any of its aliases")
+	filters["project"] = fs.String("project", "", "Findings of the products of the project")
+	filters["tool"] = fs.String("tool", "", "Findings reported by the tool, e.g. gosec")
+	filters["level"] = fs.String("level", "", "Findings of the level (none, note, warning or error)")
+	filters["cwe"] = fs.String("cwe", "", "Findings of the weakness, e.g. CWE-79")
+	filters["cve"] = fs.String("cve", "", "Findings of the vulnerability, e.g. CVE-2021-44228")
+	filters["status"] = fs.String("status", "", fmt.Sprintf("Findings of the status, open or one of %v", shared.AllowedStatusKinds))
+	fs.Parse(args)
+	loadConfig(fs)
+	if !isValidFormat(*format) {
+		return
+	}
+
+	findings, err := cli.Findings(client(), listQuery(filters))
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch findings: %v\n", err)
+		Exit(1)
+		return
+	}
+
+	var rows [][]string
+	for _, finding := range findings {
+		rows = append(rows, finding.Row())
+	}
+	render(*format, cli.FindingColumns, rows, findings)
+}
+
+func handleEngagementsMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeEngagements), flag.ExitOnError)
+	format := queryFlags(fs)
+	filters := listFlags(fs)
+	filters["product"] = fs.String("product", "", "Engagements of the product, its id or any of its aliases")
+	filters["project"] = fs.String("project", "", "Engagements of the products of the project")
+	filters["tool"] = fs.String("tool", "", "Engagements of the tool, e.g. gosec")
+	fs.Parse(args)
+	loadConfig(fs)
+	if !isValidFormat(*format) {
+		return
+	}
+
+	engagements, err := cli.Engagements(client(), listQuery(filters))
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch engagements: %v\n", err)
+		Exit(1)
+		return
+	}
+
+	var rows [][]string
+	for _, engagement := range engagements {
+		rows = append(rows, engagement.Row())
+	}
+	render(*format, cli.EngagementColumns, rows, engagements)
+}
+
+func handleShowMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeShow), flag.ExitOnError)
+	format := queryFlags(fs)
+	fs.Parse(args)
+	loadConfig(fs)
+	if !isValidFormat(*format) {
+		return
+	}
+
+	if len(fs.Args()) != 1 {
+		fmt.Println("Error: show mode takes one product id")
+		Exit(1)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("id", fs.Arg(0))
+	products, err := cli.Products(client(), query)
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch product: %v\n", err)
+		Exit(1)
+		return
+	}
+	if len(products) == 0 {
+		fmt.Printf("Error: Product '%s' not found\n", fs.Arg(0))
+		Exit(1)
+		return
+	}
+	product := products[0]
+
+	query = url.Values{}
+	query.Set("product", product.ProductID)
+	findings, err := cli.Findings(client(), query)
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch findings: %v\n", err)
+		Exit(1)
+		return
+	}
+	engagements, err := cli.Engagements(client(), query)
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch engagements: %v\n", err)
+		Exit(1)
+		return
+	}
+
+	if *format == cli.OutputFormatJSON {
+		render(*format, nil, nil, struct {
+			Product     cli.Product      `json:"product"`
+			Findings    []cli.Finding    `json:"findings"`
+			Engagements []cli.Engagement `json:"engagements"`
+		}{product, findings, engagements})
+		return
+	}
+
+	// The product as a two column table, then its engagements and findings
+	details := [][]string{
+		{"Id", product.ProductID},
+		{"Name", product.Name},
+		{"Type", product.Type},
+		{"Project", product.Project},
+		{"Ref", product.Ref},
+		{"Author", product.Author},
+		{"Worker", product.Worker},
+		{"Purl", product.Purl},
+		{"CPE", product.CPE},
+		{"Build-id", product.BuildID},
+		{"Dirty", strconv.FormatBool(product.Dirty)},
+		{"Aliases", strings.Join(product.Aliases, " ")},
+		{"Created", product.CreatedAt.Format(time.RFC3339)},
+	}
+	render(*format, []string{"FIELD", "VALUE"}, details, nil)
+
+	var rows [][]string
+	for _, engagement := range engagements {
+		rows = append(rows, engagement.Row())
+	}
+	fmt.Println()
+	render(*format, cli.EngagementColumns, rows, nil)
+
+	rows = nil
+	for _, finding := range findings {
+		rows = append(rows, finding.Row())
+	}
+	fmt.Println()
+	render(*format, cli.FindingColumns, rows, nil)
+}
+
+func handleTriageMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeTriage), flag.ExitOnError)
+	fs.String("profile", "", "Configuration profile")
+	fs.String("server", "", "ASPM server URL (default from configuration)")
+	rule := fs.String("rule", "", "Rule of the findings, with -path instead of a finding id")
+	findingPath := fs.String("path", "", "Path of the findings, with -rule")
+	product := fs.String("product", "", "Product of the findings (default the HEAD commit of the current repository)")
+	status := fs.String("status", "", fmt.Sprintf("Decision %v", shared.AllowedStatusKinds))
+	reason := fs.String("reason", "", "Why the decision was made")
+	propagate := fs.String("propagate", "", "Products linked to the product sharing the decision (forward, backward or bidirectional)")
+	interactive := fs.Bool("interactive", false, "Walk the open findings of the product and prompt for decisions")
+	fs.Parse(args)
+	loadConfig(fs)
+
+	propagation := shared.StatusPropagation(*propagate)
+	if !shared.IsValidStatusPropagation(propagation) {
+		fmt.Printf("Error: Invalid propagation '%s'\n", *propagate)
+		Exit(1)
+		return
+	}
+
+	triagePayload := shared.TriageMessageBody{
+		Status:      shared.StatusKind(*status),
+		Reason:      *reason,
+		Propagation: propagation,
+		Author:      cli.GetUserFromGit(),
+	}
+
+	if *interactive || *rule != "" {
+		triagePayload.ProductID = *product
+		if triagePayload.ProductID == "" {
+			var err error
+			if triagePayload.ProductID, err = cli.IdGit("."); err != nil {
+				fmt.Printf("Error: Invalid product (HEAD of the current repository): %v\n", err)
+				Exit(1)
+				return
+			}
 		}
-		productName, err = cli.NameBin(productPath)
+	}
+
+	if *interactive {
+		triageInteractive(triagePayload)
+		return
+	}
+
+	if !shared.IsValidStatusKind(triagePayload.Status) {
+		fmt.Printf("Error: Invalid status '%s', expected one of %v\n", *status, shared.AllowedStatusKinds)
+		Exit(1)
+		return
+	}
+
+	switch {
+	case fs.NArg() == 1 && *rule == "" && *findingPath == "":
+		id, err := strconv.ParseUint(fs.Arg(0), 10, 0)
 		if err != nil {
-			fmt.Printf("Error: Invalid product (name) '%s': %v\n", productPath, err)
+			fmt.Printf("Error: Invalid finding id '%s'\n", fs.Arg(0))
 			Exit(1)
+			return
 		}
+		triagePayload.FindingID = uint(id)
+	case fs.NArg() == 0 && *rule != "" && *findingPath != "":
+		triagePayload.Rule = *rule
+		triagePayload.Path = *findingPath
+	default:
+		fmt.Println("Error: triage mode takes a finding id, or -rule and -path")
+		Exit(1)
+		return
 	}
 
-	// Processing Origins
-	for _, originPath = range originsPath {
-		originInfo, err = os.Stat(originPath)
-		if os.IsNotExist(err) {
-			fmt.Printf("Error: Origin not found '%s'", originPath)
+	fmt.Printf("Running in 'triage' mode: finding=%d, product=%s, rule=%s, path=%s, status=%s, propagation=%s\n",
+		triagePayload.FindingID, triagePayload.ProductID, triagePayload.Rule, triagePayload.Path, triagePayload.Status, triagePayload.Propagation)
+
+	// Not spooled, the decision is made interactively
+	if err := client().Post("/triage", triagePayload); err != nil {
+		fmt.Printf("Error: Failed to triage findings: %v\n", err)
+		Exit(1)
+		return
+	}
+}
+
+// triageInteractive prompts for a decision on each open finding of the product, the payload carries the product and propagation
+func triageInteractive(triagePayload shared.TriageMessageBody) {
+	query := url.Values{}
+	query.Set("product", triagePayload.ProductID)
+	query.Set("status", "open")
+	findings, err := cli.Findings(client(), query)
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch findings: %v\n", err)
+		Exit(1)
+		return
+	}
+	if len(findings) == 0 {
+		fmt.Printf("No open findings of product '%s'\n", triagePayload.ProductID)
+		return
+	}
+
+	decisions := map[string]shared.StatusKind{"f": shared.StatusFalsePositive, "n": shared.StatusNoImpact, "c": shared.StatusConfirmed}
+	reader := bufio.NewReader(stdin)
+	for i, finding := range findings {
+		fmt.Printf("\n[%d/%d] %s %s at %s\n  %s\n", i+1, len(findings), finding.Level, finding.VulnerabilityID, finding.LocationHash, finding.Text)
+		fmt.Print("Decision: [f]alse positive, [n]o impact, [c]onfirmed, [s]kip, [q]uit? ")
+		answer, err := reader.ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer == "q" || (err != nil && answer == "") {
+			fmt.Println()
+			return
+		}
+		kind, ok := decisions[answer]
+		if !ok {
+			continue
+		}
+
+		fmt.Print("Reason: ")
+		reason, _ := reader.ReadString('\n')
+
+		decision := triagePayload
+		decision.FindingID = finding.ID
+		decision.ProductID = ""
+		decision.Status = kind
+		decision.Reason = strings.TrimSpace(reason)
+		if err := client().Post("/triage", decision); err != nil {
+			fmt.Printf("Error: Failed to triage finding %d: %v\n", finding.ID, err)
 			Exit(1)
+			return
 		}
+		fmt.Printf("Finding %d marked %s\n", finding.ID, kind)
+	}
+}
+
+func handleGraphMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeGraph), flag.ExitOnError)
+	fs.String("profile", "", "Configuration profile")
+	fs.String("server", "", "ASPM server URL (default from configuration)")
+	up := fs.Bool("up", false, "Only show the origins the product is made of")
+	down := fs.Bool("down", false, "Only show the products made of the product")
+	depth := fs.Int("depth", 0, "Maximum number of links to follow (default from server)")
+	format := fs.String("format", cli.GraphFormatDefault, fmt.Sprintf("Output format %v", cli.GraphFormats))
+	fs.Parse(args)
+	loadConfig(fs)
+
+	if len(fs.Args()) != 1 {
+		fmt.Println("Error: graph mode takes one product path or id")
+		Exit(1)
+		return
+	}
+	if !cli.IsValidGraphFormat(*format) {
+		fmt.Printf("Error: Invalid format '%s'\n", *format)
+		Exit(1)
+		return
+	}
+	if *depth < 0 {
+		fmt.Printf("Error: Invalid depth '%d'\n", *depth)
+		Exit(1)
+		return
+	}
+
+	direction := "both"
+	switch {
+	case *up && *down:
+	case *up:
+		direction = "up"
+	case *down:
+		direction = "down"
+	}
+
+	// A path is identified the way collect does it, anything else is taken for a product id
+	id := fs.Arg(0)
+	if _, err := os.Stat(id); err == nil {
+		product, ok := describeArtefact("product", id)
+		if !ok {
+			return
+		}
+		id = product.Id
+	}
+
+	root, err := cli.Lineage(client(), id, direction, *depth)
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch lineage: %v\n", err)
+		Exit(1)
+		return
+	}
+	if err := cli.RenderGraph(os.Stdout, *format, root); err != nil {
+		fmt.Printf("Error: Failed to render output: %v\n", err)
+		Exit(1)
+	}
+}
+
+// handleBlastMode lists what is made of the products affected by a CVE, a package or a finding, the shipped ones first
+func handleBlastMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeBlast), flag.ExitOnError)
+	format := queryFlags(fs)
+	leaves := fs.Bool("leaves", false, "Only list the products nothing is made of, the ones shipped")
+	fs.Parse(args)
+	loadConfig(fs)
+	if !isValidFormat(*format) {
+		return
+	}
+
+	if len(fs.Args()) != 1 {
+		fmt.Println("Error: blast mode takes one CVE, purl or finding id")
+		Exit(1)
+		return
+	}
 
-		if originInfo.IsDir() {
-			originArtefactType = shared.ArtefactTypeGit
+	radius, err := cli.Blast(client(), fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: Failed to fetch blast radius: %v\n", err)
+		Exit(1)
+		return
+	}
+
+	products := []cli.BlastProduct{}
+	for _, product := range radius.Products {
+		if product.Leaf || !*leaves {
+			products = append(products, product)
+		}
+	}
+	sort.SliceStable(products, func(i, j int) bool { return products[i].Leaf && !products[j].Leaf })
+
+	if *format != cli.OutputFormatJSON {
+		fmt.Printf("Affected %d products, %d products made of them\n", len(radius.Affected), len(products))
+	}
+	var rows [][]string
+	for _, product := range products {
+		rows = append(rows, product.Row())
+	}
+	render(*format, cli.BlastColumns, rows, cli.BlastRadius{Affected: radius.Affected, Products: products})
+}
+
+func handleUploadMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeUpload), flag.ExitOnError)
+	fs.String("profile", "", "Configuration profile")
+	fs.String("server", "", "ASPM server URL (default from configuration)")
+	fs.Parse(args)
+	loadConfig(fs)
+
+	if len(fs.Args()) == 0 {
+		fmt.Println("Error: at least one payload file required")
+		Exit(1)
+		return
+	}
+
+	fmt.Printf("Running in 'upload' mode: files=%v\n", fs.Args())
+
+	// Same as flush, uploads are sent in order and the first failure stops the rest
+	for _, path := range fs.Args() {
+		var uploads []cli.Upload
+		var err error
+		if strings.HasSuffix(path, cli.AttestationExtension) {
+			uploads, err = cli.ReadAttestations(path)
 		} else {
-			originArtefactType = shared.ArtefactTypeBin
+			uploads, err = cli.ReadPayloadFile(path)
+		}
+		if err != nil {
+			fmt.Printf("Error: Invalid payload file '%s': %v\n", path, err)
+			Exit(1)
+			return
 		}
 
-		if originArtefactType == shared.ArtefactTypeGit {
-			originId, err = cli.IdGit(originPath)
-			if err != nil {
-				fmt.Printf("Error: Invalid origin (id) '%s': %v\n", originPath, err)
+		for _, u := range uploads {
+			if err := client().Post(u.Endpoint, u.Payload); err != nil {
+				fmt.Printf("Error: Failed to upload '%s' to '%s': %v\n", path, u.Endpoint, err)
 				Exit(1)
+				return
 			}
-			originName, err = cli.NameGit(originPath)
-			if err != nil {
-				fmt.Printf("Error: Invalid origin (name) '%s': %v\n", originPath, err)
-				Exit(1)
-			}
-			originAuthor = cli.GetAuthorFromGit()
 		}
+		fmt.Printf("Uploaded %d payloads from '%s'\n", len(uploads), path)
+	}
+}
 
-		if originArtefactType == shared.ArtefactTypeBin {
-			originId, err = cli.IdBin(originPath)
-			if err != nil {
-				fmt.Printf("Error: Invalid origin (id) '%s': %v\n", originPath, err)
-				Exit(1)
-			}
+func handleKeygenMode(args []string) {
+	fs := flag.NewFlagSet(string(shared.CliModeKeygen), flag.ExitOnError)
+	fs.Parse(args)
 
-			originName, err = cli.NameBin(originPath)
-			if err != nil {
-				fmt.Printf("Error: Invalid origin (name) '%s': %v\n", originPath, err)
-				Exit(1)
+	if len(fs.Args()) != 1 {
+		fmt.Println("Error: keygen mode takes the private key file to create")
+		Exit(1)
+		return
+	}
+	keyPath := fs.Arg(0)
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		fmt.Printf("Error: Failed to generate key: %v\n", err)
+		Exit(1)
+		return
+	}
+	privatePEM, err := shared.MarshalPrivateKey(private)
+	if err == nil {
+		// Never overwrite a key, payloads signed with it could not be told from forged ones anymore
+		var file *os.File
+		if file, err = os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600); err == nil {
+			if _, err = file.Write(privatePEM); err == nil {
+				err = file.Close()
+			} else {
+				file.Close()
 			}
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: Failed to write private key '%s': %v\n", keyPath, err)
+		Exit(1)
+		return
+	}
+
+	publicPEM, _ := shared.MarshalPublicKey(public)
+	if err = os.WriteFile(keyPath+".pub", publicPEM, 0o644); err != nil {
+		fmt.Printf("Error: Failed to write public key '%s.pub': %v\n", keyPath, err)
+		Exit(1)
+		return
+	}
+
+	fmt.Printf("Created key %s in '%s', register '%s.pub' on the server and set 'signing_key' to sign uploads\n", shared.KeyID(public), keyPath, keyPath)
+}
+
+// queryFlags registers the flags of the modes reading from the server
+func queryFlags(fs *flag.FlagSet) *string {
+	fs.String("profile", "", "Configuration profile")
+	fs.String("server", "", "ASPM server URL (default from configuration)")
+	return fs.String("format", cli.OutputFormatDefault, fmt.Sprintf("Output format %v", cli.OutputFormats))
+}
+
+// listFlags registers the sorting and date range flags of the list modes, filters of the mode are added to the map
+func listFlags(fs *flag.FlagSet) map[string]*string {
+	return map[string]*string{
+		"sort":  fs.String("sort", "", "Sort by the column, descending with a leading '-', e.g. -created_at"),
+		"since": fs.String("since", "", "Created at or after the time, RFC 3339 or a date"),
+		"until": fs.String("until", "", "Created before the time, RFC 3339 or a date"),
+	}
+}
+
+// listQuery makes the query of the filters set on the command line
+func listQuery(filters map[string]*string) url.Values {
+	query := url.Values{}
+	for name, value := range filters {
+		if *value != "" {
+			query.Set(name, *value)
+		}
+	}
+	return query
+}
+
+func isValidFormat(format string) bool {
+	if !cli.IsValidOutputFormat(format) {
+		fmt.Printf("Error: Invalid format '%s'\n", format)
+		Exit(1)
+		return false
+	}
+	return true
+}
+
+func render(format string, columns []string, rows [][]string, data interface{}) {
+	if err := cli.Render(os.Stdout, format, columns, rows, data); err != nil {
+		fmt.Printf("Error: Failed to render output: %v\n", err)
+		Exit(1)
+	}
+}
+
+// uploadFlags registers flags of the modes sending data to the server
+func uploadFlags(fs *flag.FlagSet) {
+	fs.String("profile", "", "Configuration profile")
+	fs.String("server", "", "ASPM server URL (default from configuration)")
+	fs.String("spool", "", "Spool directory for failed uploads (default from configuration)")
+	fs.Bool("fail-on-upload", false, "Exit with error if upload fails (default from configuration)")
+	fs.String("env-include", "", "Comma separated patterns of extra environment variables to send (default from configuration)")
+	fs.String("env-exclude", "", "Comma separated patterns of environment variables never to send (default from configuration)")
+	fs.String("ignore", "", "Comma separated patterns skipped when hashing a non-git directory (default from configuration)")
+	fs.String("signing-key", "", "Private key file payloads are signed with (default from configuration)")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the payloads instead of uploading them")
+	fs.StringVar(&outPath, "out", "", "Write the payloads to the file instead of uploading them, see 'upload' mode")
+	outbox = nil
+}
+
+// loadConfig reads the configuration profile and puts flags set on the command line on top of it,
+// so the precedence is flags > env > repo file > user file
+func loadConfig(fs *flag.FlagSet) {
+	var err error
+	var profile string
+	if f := fs.Lookup("profile"); f != nil {
+		profile = f.Value.String()
+	}
+
+	config, err = cli.LoadConfig(profile)
+	if err != nil {
+		fmt.Printf("Error: Invalid configuration: %v\n", err)
+		Exit(1)
+		return
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "server":
+			config.Server = value
+			config.ServerFrom = ""
+		case "spool":
+			config.Spool = value
+		case "signing-key":
+			config.SigningKey = value
+		case "fail-on-upload":
+			failOnUpload := value == "true"
+			config.FailOnUpload = &failOnUpload
+		case "env-include":
+			config.Environment.Include = shared.NewEnvironmentFilter(value, "").Include
+		case "env-exclude":
+			config.Environment.Exclude = shared.NewEnvironmentFilter("", value).Exclude
+		case "ignore":
+			config.Ignore = splitList(value)
+		case "method":
+			config.Method = shared.ProductionMethod(value)
+		case "level":
+			config.Gate.Level = value
+		case "max":
+			config.Gate.Max, _ = strconv.Atoi(value)
+		case "signed":
+			config.Gate.Signed = value == "true"
+		}
+	})
+}
+
+func splitList(value string) []string {
+	var result []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// client returns the server client, creating it from the configuration on first use
+func client() cli.ASPMClientInterface {
+	if aspmClient == nil {
+		var key string
+		if err := config.CheckServer(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			Exit(1)
+		} else if key, err = config.Credential.Key(); err != nil {
+			fmt.Printf("Error: Failed to read server key: %v\n", err)
+			Exit(1)
+		}
+		aspmClient = cli.NewASPMClient(config.Server, key)
+	}
 
+	return aspmClient
+}
+
+// identityFlags registers the package identifiers of the artefact, which the tools can't derive from the file
+func identityFlags(fs *flag.FlagSet) (*string, *string) {
+	purl := fs.String("purl", "", "Package URL of the artefact, e.g. pkg:npm/left-pad@1.3.0")
+	cpe := fs.String("cpe", "", "CPE name of the artefact, e.g. cpe:2.3:a:apache:log4j:2.14.1:*:*:*:*:*:*:*")
+	return purl, cpe
+}
+
+// identifyArtefact validates and normalizes the package identifiers given for the artefact
+func identifyArtefact(role string, artefact *shared.ProductMessage, purl string, cpe string) bool {
+	if purl != "" {
+		parsed, err := shared.ParsePurl(purl)
+		if err != nil {
+			fmt.Printf("Error: Invalid %s (purl) '%s': %v\n", role, purl, err)
+			Exit(1)
+			return false
+		}
+		artefact.Purl = parsed.String()
+	}
+	if cpe != "" {
+		normalized, err := shared.NormalizeCPE(cpe)
+		if err != nil {
+			fmt.Printf("Error: Invalid %s (cpe) '%s': %v\n", role, cpe, err)
+			Exit(1)
+			return false
 		}
+		artefact.CPE = normalized
+	}
+	return true
+}
+
+// describeArtefact identifies the artefact at the path, role is used in error messages
+func describeArtefact(role string, path string) (shared.ProductMessage, bool) {
+	artefact, err := readArtefact(role, path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		Exit(1)
+		return artefact, false
+	}
+	warnArtefact(role, path, artefact)
+	return artefact, true
+}
+
+// warnArtefact tells about the artefact what is worth knowing but does not stop the upload
+func warnArtefact(role string, path string, artefact shared.ProductMessage) {
+	if artefact.Type == shared.ArtefactTypeBin && artefact.Dirty {
+		fmt.Printf("Warning: %s '%s' was built from a modified source tree\n", role, path)
+	}
+}
+
+// readArtefact identifies the artefact at the path without printing, so origins can be read on worker goroutines
+func readArtefact(role string, path string) (shared.ProductMessage, error) {
+	var err error
+	var artefact shared.ProductMessage
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return artefact, fmt.Errorf("%s%s not found '%s'", strings.ToUpper(role[:1]), role[1:], path)
+	}
+
+	// Files are read once, for the image they are or for the digests they are identified by
+	var image *cli.Image
+	if info.IsDir() {
+		image, err = cli.ReadImage(path, false)
+	} else {
+		image, artefact.Digests, err = cli.ReadImageOrDigests(path)
+	}
+	switch {
+	case err == nil:
+		artefact = image.ProductMessage()
+	case !errors.Is(err, cli.ErrNotImage):
+		return artefact, fmt.Errorf("Invalid %s (image) '%s': %w", role, path, err)
+	case info.IsDir() && cli.IsGit(path):
+		artefact.Type = shared.ArtefactTypeGit
+	case info.IsDir():
+		artefact.Type = shared.ArtefactTypeDir
+	default:
+		artefact.Type = shared.ArtefactTypeBin
+	}
 
+	switch artefact.Type {
+	case shared.ArtefactTypeGit:
+		if artefact.Id, err = cli.IdGit(path); err != nil {
+			return artefact, fmt.Errorf("Invalid %s (id) '%s': %w", role, path, err)
+		}
+		if artefact.Name, err = cli.NameGit(path); err != nil {
+			return artefact, fmt.Errorf("Invalid %s (name) '%s': %w", role, path, err)
+		}
+		artefact.Author = cli.GetAuthorFromGit()
+	case shared.ArtefactTypeBin:
+		artefact.Id = artefact.Digests["sha1"]
+		if debug, err := cli.ReadELFDebug(path); err == nil {
+			artefact.BuildID = debug.BuildID
+		}
+		if build, err := cli.ReadGoBuild(path); err == nil && build.Modified {
+			artefact.Dirty = true
+		}
+		if artefact.Name, err = cli.NameBin(path); err != nil {
+			return artefact, fmt.Errorf("Invalid %s (name) '%s': %w", role, path, err)
+		}
+	case shared.ArtefactTypeDir:
+		if artefact.Id, artefact.Files, err = cli.HashDir(path, config.Ignore); err != nil {
+			return artefact, fmt.Errorf("Invalid %s (id) '%s': %w", role, path, err)
+		}
+		if artefact.Name, err = cli.NameDir(path); err != nil {
+			return artefact, fmt.Errorf("Invalid %s (name) '%s': %w", role, path, err)
+		}
+	}
+
+	return artefact, nil
+}
+
+// lineage derives the origins artefacts record themselves, Go build info of binaries and lock files of source trees
+func lineage(artefact shared.ProductMessage, path string) []shared.OriginMessageBody {
+	switch artefact.Type {
+	case shared.ArtefactTypeBin:
+		return goBuildLineage(artefact, path)
+	case shared.ArtefactTypeGit, shared.ArtefactTypeDir:
+		return componentLineage(artefact, path)
+	}
+	return nil
+}
+
+// componentLineage links a source tree to the library versions its lock files pin
+func componentLineage(artefact shared.ProductMessage, path string) []shared.OriginMessageBody {
+	components, err := cli.ReadComponents(path, config.Ignore)
+	if err != nil {
+		fmt.Printf("Warning: Failed to read lock files of '%s': %v\n", path, err)
+		return nil
+	}
+	if len(components) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Found %d components in lock files of '%s'\n", len(components), path)
+
+	var origins []shared.ProductMessage
+	for _, component := range components {
 		origins = append(origins, shared.ProductMessage{
-			Id:     originId,
-			Name:   originName,
-			Type:   originArtefactType,
-			Author: originAuthor,
+			Id:   component.Purl,
+			Name: component.Name,
+			Type: shared.ArtefactTypePackage,
+			Purl: component.Purl,
 		})
 	}
 
-	originPayload.Product.Id = productId
-	originPayload.Product.Name = productName
-	originPayload.Product.Type = productArtefactType
-	originPayload.Product.Author = productAuthor
-	originPayload.Origins = origins
-	originPayload.ProductionMethod = productionMethod
-	originPayload.Environment = cli.GetEnvironment()
+	return []shared.OriginMessageBody{{
+		Product:          artefact,
+		Origins:          origins,
+		ProductionMethod: shared.ProductionMethodComponent,
+	}}
+}
+
+// goBuildLineage links a Go binary to the commit and the modules recorded in its build info, nothing for other artefacts
+func goBuildLineage(product shared.ProductMessage, path string) []shared.OriginMessageBody {
+	if product.Type != shared.ArtefactTypeBin {
+		return nil
+	}
+	build, err := cli.ReadGoBuild(path)
+	if err != nil {
+		return nil
+	}
+
+	fmt.Printf("Found Go build info in '%s': revision=%s, modified=%t, modules=%d\n", path, build.Revision, build.Modified, len(build.Modules))
+
+	var payloads []shared.OriginMessageBody
+	if build.Revision != "" {
+		payloads = append(payloads, shared.OriginMessageBody{
+			Product:          product,
+			Origins:          []shared.ProductMessage{{Id: build.Revision, Type: shared.ArtefactTypeGit}},
+			ProductionMethod: shared.ProductionMethodCompile,
+		})
+	}
+
+	if len(build.Modules) > 0 {
+		var modules []shared.ProductMessage
+		for _, module := range build.Modules {
+			modules = append(modules, shared.ProductMessage{
+				Id:   module.Purl(),
+				Name: module.Path,
+				Type: shared.ArtefactTypePackage,
+				Purl: module.Purl(),
+			})
+		}
+		payloads = append(payloads, shared.OriginMessageBody{
+			Product:          product,
+			Origins:          modules,
+			ProductionMethod: shared.ProductionMethodComponent,
+		})
+	}
+
+	return payloads
+}
+
+// ciContext resolves the pipeline context, reading the artefact repository when running outside of CI
+func ciContext(artefactPath string) *shared.CIContext {
+	dir := artefactPath
+	if info, err := os.Stat(artefactPath); err != nil || !info.IsDir() {
+		dir = "."
+	}
+
+	ci := cli.GetCIContext(dir)
+	return &ci
+}
+
+// upload sends the payload to the server and spools it on failure, so it can be replayed with 'flush' later
+func upload(endpoint string, payload interface{}) {
+	payload, ok := sign(endpoint, payload)
+	if !ok {
+		return
+	}
+
+	if dryRun || outPath != "" {
+		export(endpoint, payload)
+		return
+	}
+
+	err := client().Post(endpoint, payload)
+	if err == nil {
+		return
+	}
+
+	fmt.Printf("Error: Failed to upload to '%s': %v\n", endpoint, err)
+
+	path, spoolErr := cli.Spool(config.Spool, endpoint, payload)
+	if spoolErr != nil {
+		fmt.Printf("Error: Failed to spool upload: %v\n", spoolErr)
+		Exit(1)
+		return
+	}
+	fmt.Printf("Upload spooled to '%s', run 'flush' to replay it\n", path)
 
-	aspmClient.Post("/"+string(shared.CliModeOrigin), originPayload)
+	if config.FailOnUpload != nil && *config.FailOnUpload {
+		Exit(1)
+	}
+}
 
+// export prints the payload on a dry run and adds it to the payload file,
+// the file is rewritten on every upload so it is complete whenever the run stops
+func export(endpoint string, payload interface{}) {
+	u, err := cli.NewUpload(endpoint, payload)
+	if err != nil {
+		fmt.Printf("Error: Failed to export upload to '%s': %v\n", endpoint, err)
+		Exit(1)
+		return
+	}
+
+	if dryRun {
+		indented, _ := json.MarshalIndent(u.Payload, "", "  ")
+		fmt.Printf("Dry run, POST to %s:\n%s\n", endpoint, indented)
+	}
+
+	if outPath != "" {
+		outbox = append(outbox, u)
+		if err := cli.WritePayloadFile(outPath, outbox); err != nil {
+			fmt.Printf("Error: Failed to write payload file '%s': %v\n", outPath, err)
+			Exit(1)
+			return
+		}
+	}
+}
+
+// writeAttestations writes the provenance of the payloads, signed with the configured key if any
+func writeAttestations(path string, bodies []shared.OriginMessageBody) bool {
+	key, err := config.SigningPrivateKey()
+	if err != nil {
+		fmt.Printf("Error: Invalid signing key '%s': %v\n", config.SigningKey, err)
+		Exit(1)
+		return false
+	}
+
+	var envelopes []shared.Envelope
+	for _, body := range bodies {
+		envelope, err := shared.NewEnvelope(shared.NewProvenance(body), key)
+		if err != nil {
+			fmt.Printf("Error: Failed to make provenance: %v\n", err)
+			Exit(1)
+			return false
+		}
+		envelopes = append(envelopes, envelope)
+	}
+
+	if err := cli.WriteAttestations(path, envelopes); err != nil {
+		fmt.Printf("Error: Failed to write attestations '%s': %v\n", path, err)
+		Exit(1)
+		return false
+	}
+	fmt.Printf("Wrote %d attestations to '%s'\n", len(envelopes), path)
+	return true
+}
+
+// signedPayloadTypes are the payload types of the endpoints taking signed payloads
+var signedPayloadTypes = map[string]string{
+	"/" + string(shared.CliModeCollect): shared.CollectPayloadType,
+	"/" + string(shared.CliModeOrigin):  shared.OriginPayloadType,
+}
+
+// sign wraps the payload in an envelope signed with the configured key, it stays as it is when there is none
+func sign(endpoint string, payload interface{}) (interface{}, bool) {
+	payloadType, signed := signedPayloadTypes[endpoint]
+	if !signed {
+		return payload, true
+	}
+	key, err := config.SigningPrivateKey()
+	if err != nil {
+		fmt.Printf("Error: Invalid signing key '%s': %v\n", config.SigningKey, err)
+		Exit(1)
+		return nil, false
+	}
+	if key == nil {
+		return payload, true
+	}
+
+	content, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Error: Failed to sign payload: %v\n", err)
+		Exit(1)
+		return nil, false
+	}
+	return shared.Seal(payloadType, content, key), true
 }
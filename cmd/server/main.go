@@ -20,12 +20,22 @@ func init() {
 func main() {
 	http.HandleFunc("POST /api/v1/collect", server.CollectHandler)
 	http.HandleFunc("POST /api/v1/origin", server.OriginHandler)
+	http.HandleFunc("POST /api/v1/attestation", server.AttestationHandler)
 	http.HandleFunc("GET /api/v1/gw", server.GWHandler)
+	http.HandleFunc("POST /api/v1/admin/merge", server.MergeHandler)
+	http.HandleFunc("POST /api/v1/admin/key", server.KeyHandler)
+	http.HandleFunc("POST /api/v1/triage", server.TriageHandler)
 	http.HandleFunc("GET /api/v1/ui/product", server.UIProductHandler)
 	http.HandleFunc("GET /api/v1/ui/link", server.UILinkHandler)
+	http.HandleFunc("GET /api/v1/ui/lineage", server.UILineageHandler)
+	http.HandleFunc("GET /api/v1/ui/component", server.UIComponentHandler)
+	http.HandleFunc("GET /api/v1/ui/blast-radius", server.UIBlastRadiusHandler)
 	http.HandleFunc("GET /api/v1/ui/engagement", server.UIEngagementHandler)
 	http.HandleFunc("GET /api/v1/ui/vulnerability", server.UIVulnerabilityHandler)
 	http.HandleFunc("GET /api/v1/ui/version", server.UIVersionHandler)
+	http.HandleFunc("GET /api/v2/products/{productId...}", server.ProductDetailHandler)
+	http.HandleFunc("GET /api/v1/graphql", server.GraphQLHandler)
+	http.HandleFunc("POST /api/v1/graphql", server.GraphQLHandler)
 
 	fmt.Println("Server is running on :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/b4bay/aspm/internal/server"
+	"github.com/b4bay/aspm/internal/server/graphql"
 	"github.com/b4bay/aspm/internal/server/sarif"
 	"github.com/b4bay/aspm/internal/shared"
 	"gorm.io/driver/sqlite"
@@ -11,6 +15,8 @@ import (
 	"gorm.io/gorm/clause"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -21,7 +27,7 @@ func setupTestDB() *gorm.DB {
 	if err != nil {
 		panic("failed to connect to in-memory database")
 	}
-	db.AutoMigrate(&server.Product{}, &server.Link{}, &server.Engagement{}, &server.Vulnerability{}, &server.Status{})
+	db.AutoMigrate(&server.Product{}, &server.Link{}, &server.Engagement{}, &server.Vulnerability{}, &server.Status{}, &server.Receipt{}, &server.ProductFile{}, &server.ProductAlias{}, &server.SigningKey{})
 	return db
 }
 
@@ -86,6 +92,131 @@ func TestCollectHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("repeated request", func(t *testing.T) {
+		// Same artefact and reports under other names, as a re-run CI job would send them
+		body := shared.CollectMessageBody{
+			Artefact: shared.ProductMessage{
+				Type: shared.ArtefactTypeGit,
+				Id:   "test-artifact",
+			},
+			Reports: map[string]string{"gosec-rerun.sarif": sarif.MockGosecReport, "govuncheck-rerun.sarif": sarif.MockGovulncheckReport},
+		}
+		body.IdempotencyKey = shared.CollectIdempotencyKey(body.Artefact.Id, body.Reports)
+
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Failed to marshal JSON: %v", err)
+		}
+
+		var engagementsBefore int64
+		db.Model(&server.Engagement{}).Where("product_id = ?", body.Artefact.Id).Count(&engagementsBefore)
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/collect", bytes.NewReader(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		server.CollectHandler(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		if rec.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("Expected repeated upload to be recognized, got headers %v", rec.Header())
+		}
+
+		var receipt server.Receipt
+		if err := db.First(&receipt, "idempotency_key = ?", body.IdempotencyKey).Error; err != nil {
+			t.Errorf("Failed to find receipt: %v", err)
+		}
+		if rec.Header().Get("X-Receipt-Id") != fmt.Sprint(receipt.ID) {
+			t.Errorf("Expected original receipt %d, got %s", receipt.ID, rec.Header().Get("X-Receipt-Id"))
+		}
+
+		var engagementsAfter int64
+		db.Model(&server.Engagement{}).Where("product_id = ?", body.Artefact.Id).Count(&engagementsAfter)
+		if engagementsAfter != engagementsBefore {
+			t.Errorf("Expected no new engagements, got %d instead of %d", engagementsAfter, engagementsBefore)
+		}
+	})
+
+	t.Run("failed request", func(t *testing.T) {
+		// A report failing leaves nothing behind, neither the other reports nor the receipt, so a retry processes them all
+		body := shared.CollectMessageBody{
+			Artefact: shared.ProductMessage{Type: shared.ArtefactTypeGit, Id: "test-artifact-failed"},
+			Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport, "broken.sarif": "not a report"},
+		}
+		body.IdempotencyKey = shared.CollectIdempotencyKey(body.Artefact.Id, body.Reports)
+		jsonBody, _ := json.Marshal(body)
+
+		rec := httptest.NewRecorder()
+		server.CollectHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collect", bytes.NewReader(jsonBody)))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+		}
+
+		var engagements, receipts int64
+		db.Model(&server.Engagement{}).Where("product_id = ?", body.Artefact.Id).Count(&engagements)
+		db.Model(&server.Receipt{}).Where("idempotency_key = ?", body.IdempotencyKey).Count(&receipts)
+		if engagements != 0 || receipts != 0 {
+			t.Errorf("Expected no engagements and no receipt, got %d and %d", engagements, receipts)
+		}
+	})
+
+	t.Run("dir artefact", func(t *testing.T) {
+		body := shared.CollectMessageBody{
+			Artefact: shared.ProductMessage{
+				Type:  shared.ArtefactTypeDir,
+				Id:    "test-dir",
+				Files: map[string]string{"main.go": "8d2f", "vendor/lib/main.go": "a1b2"},
+			},
+			Reports: map[string]string{"gosec.sarif": sarif.MockGosecReport},
+		}
+
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Failed to marshal JSON: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/collect", bytes.NewReader(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+
+		server.CollectHandler(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var files int64
+		db.Model(&server.ProductFile{}).Where("product_id = ?", body.Artefact.Id).Count(&files)
+		if files != 2 {
+			t.Errorf("Expected 2 product files, got %d", files)
+		}
+
+		// Gosec reports main.go, which is the file of the root, not the vendored one
+		var vulnerabilities []server.Vulnerability
+		db.Where("product_id = ?", body.Artefact.Id).Find(&vulnerabilities)
+		if len(vulnerabilities) == 0 {
+			t.Fatal("Expected vulnerabilities for dir artefact")
+		}
+		for _, v := range vulnerabilities {
+			if v.FileHash != "8d2f" {
+				t.Errorf("Expected file hash of main.go for %s, got '%s'", v.VulnerabilityID, v.FileHash)
+			}
+		}
+
+		for uri, expected := range map[string]string{
+			"file:///src/app/vendor/lib/main.go": "a1b2",
+			"/src/app/main.go":                   "8d2f",
+			"./main.go":                          "8d2f",
+			"/src/app/util.go":                   "",
+		} {
+			if hash := server.FileHashForUri(body.Artefact.Files, uri); hash != expected {
+				t.Errorf("Expected '%s' for %s, got '%s'", expected, uri, hash)
+			}
+		}
+	})
+
 	t.Run("invalid JSON", func(t *testing.T) {
 		// Create an invalid JSON body
 		invalidJSON := "{invalid}"
@@ -139,6 +270,29 @@ func TestOriginHandler(t *testing.T) {
 			},
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name: "Valid request from GitHub Actions",
+			requestBody: shared.OriginMessageBody{
+				Environment: map[string]string{
+					"GITHUB_ACTIONS":    "true",
+					"GITHUB_REPOSITORY": "b4bay/aspm",
+					"GITHUB_REF_NAME":   "main",
+					"GITHUB_ACTOR":      "b4bay",
+					"RUNNER_NAME":       "GitHub Actions 2",
+				},
+				Product: shared.ProductMessage{
+					Name:  "aspm",
+					Type:  shared.ArtefactTypeBin,
+					Id:    "product-456",
+					Dirty: true,
+				},
+				Origins: []shared.ProductMessage{
+					{Id: "origin-3", Name: "main", Type: shared.ArtefactTypeGit},
+				},
+				ProductionMethod: shared.ProductionMethodCompile,
+			},
+			expectedStatus: http.StatusOK,
+		},
 	}
 
 	for _, tt := range tests {
@@ -173,6 +327,12 @@ func TestOriginHandler(t *testing.T) {
 					product.Worker != server.GetWorkerFromEnvironment(tt.requestBody.Environment) {
 					t.Errorf("Product fields not properly populated: %+v", product)
 				}
+				if product.Project == "" || product.Author == "" || product.Worker == "" {
+					t.Errorf("Product CI context is missing: %+v", product)
+				}
+				if product.Dirty != tt.requestBody.Product.Dirty {
+					t.Errorf("Expected dirty flag %t, got %t", tt.requestBody.Product.Dirty, product.Dirty)
+				}
 
 				for _, o := range tt.requestBody.Origins {
 					var origin server.Product
@@ -210,3 +370,1097 @@ func TestGwHandler(t *testing.T) {
 		t.Errorf("unexpected response body: %s", body)
 	}
 }
+
+func TestProductAliasesAndMerge(t *testing.T) {
+	db = setupTestDB()
+
+	post := func(handler http.HandlerFunc, target string, payload interface{}, key string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to marshal request body: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	binary := shared.ProductMessage{
+		Id:      "alias-sha1",
+		Name:    "aspm",
+		Type:    shared.ArtefactTypeBin,
+		Digests: map[string]string{"sha1": "alias-sha1", "sha256": "alias-sha256"},
+	}
+	if rec := post(server.OriginHandler, "/api/v1/origin", shared.OriginMessageBody{
+		Product: binary,
+		Origins: []shared.ProductMessage{{Id: "alias-source", Name: "main", Type: shared.ArtefactTypeGit}},
+	}, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	t.Run("lookup by alias", func(t *testing.T) {
+		// A report of the image layer refers to the binary by SHA-256 only
+		if rec := post(server.CollectHandler, "/api/v1/collect", shared.CollectMessageBody{
+			Artefact: shared.ProductMessage{Id: "sha256:alias-sha256", Type: shared.ArtefactTypeBin},
+			Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport},
+		}, ""); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		var engagements int64
+		db.Model(&server.Engagement{}).Where("product_id = ?", binary.Id).Count(&engagements)
+		if engagements != 1 {
+			t.Errorf("Expected engagement of the aliased product, got %d", engagements)
+		}
+
+		var products int64
+		db.Model(&server.Product{}).Where("product_id = ?", "sha256:alias-sha256").Count(&products)
+		if products != 0 {
+			t.Errorf("Expected no product created for the alias")
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		duplicate := shared.ProductMessage{Id: "alias-duplicate", Name: "aspm-stripped", Type: shared.ArtefactTypeBin, Dirty: true}
+		post(server.OriginHandler, "/api/v1/origin", shared.OriginMessageBody{
+			Product: duplicate,
+			Origins: []shared.ProductMessage{
+				{Id: "alias-library", Name: "library.so", Type: shared.ArtefactTypeBin},
+				{Id: "alias-source", Name: "main", Type: shared.ArtefactTypeGit},
+			},
+		}, "")
+		post(server.CollectHandler, "/api/v1/collect", shared.CollectMessageBody{
+			Artefact: duplicate,
+			Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport},
+		}, "")
+
+		merge := shared.MergeMessageBody{From: duplicate.Id, Into: "sha256:alias-sha256"}
+
+		server.AdminKey = ""
+		if rec := post(server.MergeHandler, "/api/v1/admin/merge", merge, "admin"); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected merge to be disabled without admin key, got %d", rec.Code)
+		}
+
+		server.AdminKey = "admin"
+		defer func() { server.AdminKey = "" }()
+		if rec := post(server.MergeHandler, "/api/v1/admin/merge", merge, "not-admin"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected merge with wrong key to be refused, got %d", rec.Code)
+		}
+		if rec := post(server.MergeHandler, "/api/v1/admin/merge", merge, "admin"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+
+		var products int64
+		db.Model(&server.Product{}).Where("product_id = ?", duplicate.Id).Count(&products)
+		if products != 0 {
+			t.Errorf("Expected merged product to be removed")
+		}
+
+		var kept server.Product
+		db.Where("product_id = ?", binary.Id).Take(&kept)
+		if !kept.Dirty {
+			t.Errorf("Expected the product kept to be dirty as the merged one was")
+		}
+
+		var links int64
+		db.Model(&server.Link{}).Where("product_id = ?", binary.Id).Count(&links)
+		if links != 2 {
+			t.Errorf("Expected links of both products, the shared one once, got %d", links)
+		}
+
+		// Both products had the same findings, they are kept once
+		var engagements, duplicates int64
+		db.Model(&server.Engagement{}).Where("product_id = ?", binary.Id).Count(&engagements)
+		db.Model(&server.Vulnerability{}).Where("product_id = ?", duplicate.Id).Count(&duplicates)
+		if engagements != 2 || duplicates != 0 {
+			t.Errorf("Expected engagements and findings moved, got %d engagements and %d findings left", engagements, duplicates)
+		}
+
+		productID, err := server.ResolveProductID(db, duplicate.Id)
+		if err != nil || productID != binary.Id {
+			t.Errorf("Expected merged id to resolve to '%s', got '%s' (%v)", binary.Id, productID, err)
+		}
+	})
+}
+
+func TestStripTwinFindings(t *testing.T) {
+	db = setupTestDB()
+
+	// Scanner ran on the debug build only
+	collect, _ := json.Marshal(shared.CollectMessageBody{
+		Artefact: shared.ProductMessage{Id: "strip-debug", Type: shared.ArtefactTypeBin, BuildID: "0123"},
+		Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport},
+	})
+	rec := httptest.NewRecorder()
+	server.CollectHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collect", bytes.NewReader(collect)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	origin, _ := json.Marshal(shared.OriginMessageBody{
+		Product:          shared.ProductMessage{Id: "strip-shipped", Type: shared.ArtefactTypeBin, BuildID: "0123"},
+		Origins:          []shared.ProductMessage{{Id: "strip-debug", Type: shared.ArtefactTypeBin, BuildID: "0123"}},
+		ProductionMethod: shared.ProductionMethodStrip,
+	})
+	rec = httptest.NewRecorder()
+	server.OriginHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/origin", bytes.NewReader(origin)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.UIVulnerabilityHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/vulnerability?product=strip-shipped", nil))
+
+	var vulnerabilities []server.VulnerabilityResponse
+	if err := json.NewDecoder(rec.Body).Decode(&vulnerabilities); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(vulnerabilities) == 0 {
+		t.Fatal("Expected findings of the debug build for the shipped binary")
+	}
+	for _, v := range vulnerabilities {
+		if v.ProductID != "strip-debug" {
+			t.Errorf("Expected findings of the twin only, got product '%s'", v.ProductID)
+		}
+	}
+}
+
+func TestComponentUsage(t *testing.T) {
+	db = setupTestDB()
+
+	for _, commit := range []string{"component-commit-1", "component-commit-2"} {
+		origin, _ := json.Marshal(shared.OriginMessageBody{
+			Environment: map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REPOSITORY": "b4bay/" + commit},
+			Product:     shared.ProductMessage{Id: commit, Type: shared.ArtefactTypeGit},
+			Origins: []shared.ProductMessage{
+				{Id: "pkg:npm/lodash@4.17.21", Name: "lodash", Type: shared.ArtefactTypePackage},
+				{Id: "pkg:npm/left-pad@1.3.0", Name: "left-pad", Type: shared.ArtefactTypePackage},
+			},
+			ProductionMethod: shared.ProductionMethodComponent,
+		})
+		rec := httptest.NewRecorder()
+		server.OriginHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/origin", bytes.NewReader(origin)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.UIComponentHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/component?purl="+url.QueryEscape("pkg:npm/lodash@4.17.21"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var products []server.ProductResponse
+	if err := json.NewDecoder(rec.Body).Decode(&products); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	projects := map[string]bool{}
+	for _, p := range products {
+		projects[p.Project] = true
+	}
+	if len(products) != 2 || !projects["b4bay/component-commit-1"] || !projects["b4bay/component-commit-2"] {
+		t.Errorf("Expected both projects to use the component, got %+v", products)
+	}
+}
+
+func TestPurlIdentity(t *testing.T) {
+	db = setupTestDB()
+
+	origin := func(body shared.OriginMessageBody) int {
+		payload, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		server.OriginHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/origin", bytes.NewReader(payload)))
+		return rec.Code
+	}
+	lookup := func(query string) []server.ProductResponse {
+		rec := httptest.NewRecorder()
+		server.UIProductHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/product?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		var products []server.ProductResponse
+		if err := json.NewDecoder(rec.Body).Decode(&products); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return products
+	}
+
+	// Two arches of a package, reported with different spelling, and a binary with a declared purl and CPE
+	if code := origin(shared.OriginMessageBody{
+		Product: shared.ProductMessage{Id: "purl-image", Type: shared.ArtefactTypeImage},
+		Origins: []shared.ProductMessage{
+			{Id: "pkg:deb/debian/curl@7.50.3-1?arch=amd64", Type: shared.ArtefactTypePackage},
+			{Id: "pkg:DEB/debian/curl@7.50.3-1?distro=jessie&arch=i386", Type: shared.ArtefactTypePackage},
+			{Id: "purl-bin", Type: shared.ArtefactTypeBin, Purl: "pkg:pypi/Django_Rest@1.0", CPE: "cpe:/a:djangoproject:django:1.0"},
+		},
+		ProductionMethod: shared.ProductionMethodComponent,
+	}); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	products := lookup("purl=" + url.QueryEscape("pkg:deb/debian/curl@7.50.3-1?arch=i386&distro=jessie"))
+	if len(products) != 1 || products[0].ProductID != "pkg:deb/debian/curl@7.50.3-1?arch=i386&distro=jessie" {
+		t.Errorf("Expected the normalized i386 package, got %+v", products)
+	}
+	products = lookup("purl=" + url.QueryEscape("pkg:deb/debian/curl@7.50.3-1") + "&ignore_qualifiers=true")
+	if len(products) != 2 {
+		t.Errorf("Expected both arches ignoring qualifiers, got %+v", products)
+	}
+
+	products = lookup("purl=" + url.QueryEscape("pkg:pypi/django-rest@1.0"))
+	if len(products) != 1 || products[0].ProductID != "purl-bin" || products[0].CPE != "cpe:2.3:a:djangoproject:django:1.0:*:*:*:*:*:*:*" {
+		t.Errorf("Expected the binary with normalized CPE, got %+v", products)
+	}
+
+	// Another artefact of the same package keeps its own identity and is found by the purl too
+	if code := origin(shared.OriginMessageBody{
+		Product:          shared.ProductMessage{Id: "purl-other-bin", Type: shared.ArtefactTypeBin},
+		Origins:          []shared.ProductMessage{{Id: "purl-other-digest", Purl: "pkg:pypi/django.rest@1.0"}},
+		ProductionMethod: shared.ProductionMethodCompile,
+	}); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	var link server.Link
+	if err := db.Where("product_id = ?", "purl-other-bin").First(&link).Error; err != nil || link.OriginID != "purl-other-digest" {
+		t.Errorf("Expected the origin kept by its digest, got %+v (%v)", link, err)
+	}
+	products = lookup("purl=" + url.QueryEscape("pkg:pypi/django-rest@1.0"))
+	if len(products) != 2 {
+		t.Errorf("Expected both artefacts of the package, got %+v", products)
+	}
+
+	if code := origin(shared.OriginMessageBody{
+		Product: shared.ProductMessage{Id: "purl-invalid", Purl: "npm/left-pad"},
+	}); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid purl, got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestVulnerabilityFilters(t *testing.T) {
+	db = setupTestDB()
+
+	collect, _ := json.Marshal(shared.CollectMessageBody{
+		Environment: map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REPOSITORY": "b4bay/filters"},
+		Artefact:    shared.ProductMessage{Id: "filters-bin", Type: shared.ArtefactTypeBin},
+		Reports:     map[string]string{"gosec.sarif": sarif.MockGosecReport},
+	})
+	rec := httptest.NewRecorder()
+	server.CollectHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collect", bytes.NewReader(collect)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var all []server.Vulnerability
+	db.Where("product_id = ?", "filters-bin").Find(&all)
+	if len(all) == 0 || all[0].CWE == "" {
+		t.Fatal("Expected findings with CWE in the report")
+	}
+
+	list := func(query string) []server.VulnerabilityResponse {
+		rec := httptest.NewRecorder()
+		server.UIVulnerabilityHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/vulnerability?"+query, nil))
+		var vulnerabilities []server.VulnerabilityResponse
+		if err := json.NewDecoder(rec.Body).Decode(&vulnerabilities); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return vulnerabilities
+	}
+
+	if got := list("project=b4bay/filters"); len(got) != len(all) {
+		t.Errorf("Expected %d findings of the project, got %d", len(all), len(got))
+	}
+	if got := list("project=b4bay/other"); len(got) != 0 {
+		t.Errorf("Expected no findings of another project, got %d", len(got))
+	}
+	for _, v := range list("cwe=CWE-" + strings.TrimPrefix(all[0].CWE, "CWE-")) {
+		if v.CWE != all[0].CWE {
+			t.Errorf("Expected findings of %s only, got %s", all[0].CWE, v.CWE)
+		}
+	}
+	for _, v := range list("level=" + string(all[0].Level)) {
+		if v.Level != all[0].Level {
+			t.Errorf("Expected findings of level %s only, got %s", all[0].Level, v.Level)
+		}
+	}
+}
+
+func TestTriage(t *testing.T) {
+	db = setupTestDB()
+	server.TriageKey = "triage"
+	defer func() { server.TriageKey = "" }()
+
+	key := "triage"
+	post := func(handler http.HandlerFunc, target string, body interface{}) int {
+		payload, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+key)
+		handler(rec, req)
+		return rec.Code
+	}
+	collect := func(id string) {
+		if code := post(server.CollectHandler, "/api/v1/collect", shared.CollectMessageBody{
+			Artefact: shared.ProductMessage{Id: id, Type: shared.ArtefactTypeBin},
+			Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport},
+		}); code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	}
+	compile := func(product string) {
+		if code := post(server.OriginHandler, "/api/v1/origin", shared.OriginMessageBody{
+			Product:          shared.ProductMessage{Id: product, Type: shared.ArtefactTypeBin},
+			Origins:          []shared.ProductMessage{{Id: "triage-commit", Type: shared.ArtefactTypeGit}},
+			ProductionMethod: shared.ProductionMethodCompile,
+		}); code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	}
+	statusOf := func(product string, rule string, location string) server.VulnerabilityResponse {
+		rec := httptest.NewRecorder()
+		server.UIVulnerabilityHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/vulnerability?product="+product, nil))
+		var vulnerabilities []server.VulnerabilityResponse
+		json.NewDecoder(rec.Body).Decode(&vulnerabilities)
+		for _, v := range vulnerabilities {
+			if v.VulnerabilityID == rule && v.LocationHash == location {
+				return v
+			}
+		}
+		t.Fatalf("Finding %s at %s of %s not found", rule, location, product)
+		return server.VulnerabilityResponse{}
+	}
+
+	collect("triage-commit")
+	compile("triage-bin")
+	collect("triage-bin")
+
+	var finding server.Vulnerability
+	db.Where("product_id = ?", "triage-commit").First(&finding)
+	path, _, _ := strings.Cut(finding.LocationHash, "(")
+
+	// Decisions make the gate pass, they are not taken without the triage key
+	decision := shared.TriageMessageBody{
+		ProductID: "triage-commit", Rule: finding.VulnerabilityID, Path: path,
+		Status: shared.StatusFalsePositive, Reason: "test data", Propagation: shared.PropagationForward,
+	}
+	key = "guess"
+	if code := post(server.TriageHandler, "/api/v1/triage", decision); code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d without the triage key, got %d", http.StatusUnauthorized, code)
+	}
+	key = "triage"
+	if code := post(server.TriageHandler, "/api/v1/triage", decision); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if v := statusOf("triage-commit", finding.VulnerabilityID, finding.LocationHash); v.Status != string(shared.StatusFalsePositive) || v.StatusReason != "test data" {
+		t.Errorf("Expected the finding marked false positive, got %+v", v)
+	}
+	if v := statusOf("triage-bin", finding.VulnerabilityID, finding.LocationHash); v.Status != string(shared.StatusFalsePositive) {
+		t.Errorf("Expected the decision propagated forward to the binary, got %+v", v)
+	}
+
+	// A binary built later from the commit inherits the decision
+	compile("triage-bin-2")
+	collect("triage-bin-2")
+	if v := statusOf("triage-bin-2", finding.VulnerabilityID, finding.LocationHash); v.Status != string(shared.StatusFalsePositive) {
+		t.Errorf("Expected the decision inherited by a later build, got %+v", v)
+	}
+	var inherited int64
+	db.Model(&server.Status{}).Where("vulnerability_id IN (?)", db.Model(&server.Vulnerability{}).Select("id").Where("product_id = ?", "triage-bin-2")).
+		Count(&inherited)
+	if inherited != 1 {
+		t.Errorf("Expected only the decided finding inherited, got %d statuses", inherited)
+	}
+
+	// The latest decision is in effect
+	var decided server.Vulnerability
+	db.Where("product_id = ? AND vulnerability_id = ? AND location_hash = ?", "triage-bin", finding.VulnerabilityID, finding.LocationHash).First(&decided)
+	if code := post(server.TriageHandler, "/api/v1/triage", shared.TriageMessageBody{FindingID: decided.ID, Status: shared.StatusConfirmed}); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	rec := httptest.NewRecorder()
+	server.UIVulnerabilityHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/vulnerability?product=triage-bin&status=confirmed", nil))
+	var confirmed []server.VulnerabilityResponse
+	json.NewDecoder(rec.Body).Decode(&confirmed)
+	if len(confirmed) != 1 || confirmed[0].ID != decided.ID {
+		t.Errorf("Expected only the confirmed finding, got %+v", confirmed)
+	}
+	rec = httptest.NewRecorder()
+	server.UIVulnerabilityHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/vulnerability?product=triage-bin&status=open", nil))
+	var open []server.VulnerabilityResponse
+	json.NewDecoder(rec.Body).Decode(&open)
+	for _, v := range open {
+		if v.Status != "" || v.ID == decided.ID {
+			t.Errorf("Expected open findings only, got %+v", v)
+		}
+	}
+
+	if code := post(server.TriageHandler, "/api/v1/triage", shared.TriageMessageBody{FindingID: decided.ID, Status: "wontfix"}); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid status, got %d", http.StatusBadRequest, code)
+	}
+	if code := post(server.TriageHandler, "/api/v1/triage", shared.TriageMessageBody{ProductID: "triage-commit", Rule: "missing", Path: path, Status: shared.StatusNoImpact}); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown finding, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestLineage(t *testing.T) {
+	db = setupTestDB()
+
+	post := func(handler http.HandlerFunc, target string, body interface{}) {
+		payload, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, target, bytes.NewReader(payload)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+	}
+	link := func(product string, productType shared.ArtefactType, origin string, originType shared.ArtefactType, method shared.ProductionMethod) {
+		post(server.OriginHandler, "/api/v1/origin", shared.OriginMessageBody{
+			Product:          shared.ProductMessage{Id: product, Name: product, Type: productType},
+			Origins:          []shared.ProductMessage{{Id: origin, Name: origin, Type: originType}},
+			ProductionMethod: method,
+		})
+	}
+	lineage := func(query string) (int, server.LineageNodeResponse) {
+		rec := httptest.NewRecorder()
+		server.UILineageHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/lineage?"+query, nil))
+		var node server.LineageNodeResponse
+		json.NewDecoder(rec.Body).Decode(&node)
+		return rec.Code, node
+	}
+
+	link("lineage-bin", shared.ArtefactTypeBin, "lineage-commit", shared.ArtefactTypeGit, shared.ProductionMethodCompile)
+	link("lineage-image", shared.ArtefactTypeImage, "lineage-bin", shared.ArtefactTypeBin, shared.ProductionMethodContainerize)
+	post(server.CollectHandler, "/api/v1/collect", shared.CollectMessageBody{
+		Artefact: shared.ProductMessage{Id: "lineage-commit", Type: shared.ArtefactTypeGit},
+		Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport},
+	})
+
+	var findings int64
+	db.Model(&server.Vulnerability{}).Where("product_id = ?", "lineage-commit").Count(&findings)
+
+	code, node := lineage("id=lineage-bin")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if node.ProductID != "lineage-bin" || node.Type != shared.ArtefactTypeBin || node.Method != "" {
+		t.Errorf("Unexpected root %+v", node)
+	}
+	if len(node.Origins) != 1 || node.Origins[0].ProductID != "lineage-commit" || node.Origins[0].Method != shared.ProductionMethodCompile {
+		t.Fatalf("Expected the commit as origin, got %+v", node.Origins)
+	}
+	if node.Origins[0].OpenFindings != findings || findings == 0 {
+		t.Errorf("Expected %d open findings on the commit, got %d", findings, node.Origins[0].OpenFindings)
+	}
+	if len(node.Products) != 1 || node.Products[0].ProductID != "lineage-image" || node.Products[0].Method != shared.ProductionMethodContainerize {
+		t.Errorf("Expected the image as product, got %+v", node.Products)
+	}
+
+	_, node = lineage("id=lineage-image&direction=up&depth=1")
+	if len(node.Products) != 0 || len(node.Origins) != 1 || !node.Origins[0].Truncated || len(node.Origins[0].Origins) != 0 {
+		t.Errorf("Expected the walk up stopped after one link, got %+v", node)
+	}
+	_, node = lineage("id=lineage-commit&direction=down")
+	if len(node.Origins) != 0 || len(node.Products) != 1 || len(node.Products[0].Products) != 1 || node.Products[0].Products[0].ProductID != "lineage-image" {
+		t.Errorf("Expected the walk down to reach the image, got %+v", node)
+	}
+
+	// A commit shared by two binaries of a bundle is walked once
+	link("lineage-lib", shared.ArtefactTypeBin, "lineage-commit", shared.ArtefactTypeGit, shared.ProductionMethodCompile)
+	link("lineage-bundle", shared.ArtefactTypeDir, "lineage-bin", shared.ArtefactTypeBin, shared.ProductionMethodPack)
+	link("lineage-bundle", shared.ArtefactTypeDir, "lineage-lib", shared.ArtefactTypeBin, shared.ProductionMethodPack)
+	_, node = lineage("id=lineage-bundle&direction=up")
+	if len(node.Origins) != 2 || len(node.Origins[0].Origins) != 1 || len(node.Origins[1].Origins) != 1 {
+		t.Fatalf("Expected the commit under both binaries, got %+v", node)
+	}
+	first, second := node.Origins[0].Origins[0], node.Origins[1].Origins[0]
+	if first.Repeated || !second.Repeated || first.OpenFindings != findings || second.OpenFindings != findings {
+		t.Errorf("Expected the commit walked once and listed again as repeated, got %+v and %+v", first, second)
+	}
+
+	if code, _ := lineage("id=lineage-missing"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown product, got %d", http.StatusNotFound, code)
+	}
+	if code, _ := lineage("id=lineage-bin&direction=sideways"); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid direction, got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestSignedPayloads(t *testing.T) {
+	db = setupTestDB()
+
+	post := func(handler http.HandlerFunc, target string, body interface{}, key string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	public, private, _ := ed25519.GenerateKey(nil)
+	publicPEM, _ := shared.MarshalPublicKey(public)
+
+	server.AdminKey = "admin"
+	defer func() { server.AdminKey = "" }()
+	if rec := post(server.KeyHandler, "/api/v1/admin/key", shared.KeyMessageBody{Name: "pipeline-a", PublicKey: string(publicPEM)}, "not-admin"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected key registration with wrong key to be refused, got %d", rec.Code)
+	}
+	rec := post(server.KeyHandler, "/api/v1/admin/key", shared.KeyMessageBody{Name: "pipeline-a", PublicKey: string(publicPEM)}, "admin")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Key-Id") != shared.KeyID(public) {
+		t.Fatalf("Expected key registered, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	seal := func(payloadType string, body interface{}, key ed25519.PrivateKey) shared.Envelope {
+		content, _ := json.Marshal(body)
+		return shared.Seal(payloadType, content, key)
+	}
+
+	collect := shared.CollectMessageBody{
+		Artefact: shared.ProductMessage{Id: "signed-bin", Type: shared.ArtefactTypeBin},
+		Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport},
+	}
+	if rec := post(server.CollectHandler, "/api/v1/collect", seal(shared.CollectPayloadType, collect, private), ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var engagement server.Engagement
+	db.Where("product_id = ?", "signed-bin").First(&engagement)
+	if engagement.Signer != "pipeline-a" {
+		t.Errorf("Expected engagement signed by pipeline-a, got '%s'", engagement.Signer)
+	}
+
+	// The same content sent unsigned first and signed later is signed evidence
+	resent := shared.CollectMessageBody{
+		Artefact: shared.ProductMessage{Id: "signed-later-bin", Type: shared.ArtefactTypeBin},
+		Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport},
+	}
+	if rec := post(server.CollectHandler, "/api/v1/collect", resent, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	rec = post(server.CollectHandler, "/api/v1/collect", seal(shared.CollectPayloadType, resent, private), "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("Expected the signed upload replayed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resentEngagement server.Engagement
+	db.Where("product_id = ?", "signed-later-bin").First(&resentEngagement)
+	if resentEngagement.Signer != "pipeline-a" {
+		t.Errorf("Expected the engagement signed by the repeated upload, got '%s'", resentEngagement.Signer)
+	}
+
+	// The gate gets the signers of the evidence, a policy can require it signed
+	rec = httptest.NewRecorder()
+	server.GWHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/gw?product=signed-bin", nil))
+	var gate server.GateResponse
+	json.NewDecoder(rec.Body).Decode(&gate)
+	if len(gate.Evidence) != 1 || gate.Evidence[0].Signer != "pipeline-a" {
+		t.Errorf("Expected the signed engagement as evidence, got %+v", gate.Evidence)
+	}
+
+	origin := shared.OriginMessageBody{
+		Product:          shared.ProductMessage{Id: "signed-bin", Type: shared.ArtefactTypeBin},
+		Origins:          []shared.ProductMessage{{Id: "signed-commit", Type: shared.ArtefactTypeGit}},
+		ProductionMethod: shared.ProductionMethodCompile,
+	}
+	signed := seal(shared.OriginPayloadType, origin, private)
+	if rec := post(server.OriginHandler, "/api/v1/origin", signed, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var link server.Link
+	db.Where("product_id = ? AND origin_id = ?", "signed-bin", "signed-commit").First(&link)
+	if link.Signer != "pipeline-a" {
+		t.Errorf("Expected link signed by pipeline-a, got '%s'", link.Signer)
+	}
+
+	// The signature covers the payload as sent, fields the server does not know included
+	future := seal(shared.OriginPayloadType, map[string]interface{}{
+		"product":           map[string]string{"id": "signed-bin-2", "type": "bin"},
+		"origins":           []map[string]string{{"id": "signed-commit", "type": "git"}},
+		"production_method": "compile",
+		"added_later":       true,
+	}, private)
+	if rec := post(server.OriginHandler, "/api/v1/origin", future, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected payload with an unknown field accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A payload changed after signing, signed with an unknown key or for another endpoint, is refused
+	origin.Product.Id = "forged-bin"
+	forged := seal(shared.OriginPayloadType, origin, nil)
+	forged.Signatures = signed.Signatures
+	if rec := post(server.OriginHandler, "/api/v1/origin", forged, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected tampered payload to be refused, got %d", rec.Code)
+	}
+	_, unknown, _ := ed25519.GenerateKey(nil)
+	if rec := post(server.OriginHandler, "/api/v1/origin", seal(shared.OriginPayloadType, origin, unknown), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected payload signed with unknown key to be refused, got %d", rec.Code)
+	}
+	if rec := post(server.OriginHandler, "/api/v1/origin", seal(shared.CollectPayloadType, origin, private), ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected payload signed for another endpoint to be refused, got %d", rec.Code)
+	}
+
+	server.RequireSignature = true
+	defer func() { server.RequireSignature = false }()
+	if rec := post(server.OriginHandler, "/api/v1/origin", origin, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected unsigned payload to be refused when signatures are required, got %d", rec.Code)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	db = setupTestDB()
+
+	post := func(body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		server.AttestationHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/attestation", bytes.NewReader(payload)))
+		return rec
+	}
+
+	public, private, _ := ed25519.GenerateKey(nil)
+	if _, err := server.RegisterSigningKey(db, shared.KeyMessageBody{Name: "pipeline-b", PublicKey: base64.StdEncoding.EncodeToString(public)}); err != nil {
+		t.Fatalf("Failed to register key: %v", err)
+	}
+
+	// Provenance made by the CLI keeps products and the method as they are
+	statement := shared.NewProvenance(shared.OriginMessageBody{
+		Product:          shared.ProductMessage{Id: "sha256:attested-image", Name: "app", Type: shared.ArtefactTypeImage},
+		Origins:          []shared.ProductMessage{{Id: "attested-bin", Type: shared.ArtefactTypeBin}},
+		ProductionMethod: shared.ProductionMethodContainerize,
+	})
+	envelope, _ := shared.NewEnvelope(statement, private)
+	if rec := post(envelope); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var link server.Link
+	if err := db.Where("product_id = ? AND origin_id = ?", "sha256:attested-image", "attested-bin").First(&link).Error; err != nil {
+		t.Fatalf("Expected link from the provenance: %v", err)
+	}
+	if link.Type != shared.ProductionMethodContainerize || link.Signer != "pipeline-b" {
+		t.Errorf("Expected signed containerize link, got %+v", link)
+	}
+
+	// Provenance of other tools links digests and commits
+	foreign := shared.Statement{
+		Type:          shared.StatementType,
+		Subject:       []shared.ResourceDescriptor{{Name: "tool", Digest: map[string]string{"sha256": "feed"}}},
+		PredicateType: shared.ProvenancePredicate,
+	}
+	foreign.Predicate.BuildDefinition.BuildType = "https://slsa-framework.github.io/github-actions-buildtypes/workflow/v1"
+	foreign.Predicate.BuildDefinition.ResolvedDependencies = []shared.ResourceDescriptor{{URI: "git+https://github.com/b4bay/aspm", Digest: map[string]string{"gitCommit": "attested-commit"}}}
+	if rec := post(foreign); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	link = server.Link{}
+	if err := db.Preload("Origin").Where("product_id = ? AND origin_id = ?", "sha256:feed", "attested-commit").First(&link).Error; err != nil {
+		t.Fatalf("Expected link from the foreign provenance: %v", err)
+	}
+	if link.Type != shared.ProductionMethodCompile || link.Signer != "" || link.Origin.Type != shared.ArtefactTypeGit {
+		t.Errorf("Expected unsigned compile link from a commit, got %+v", link)
+	}
+
+	envelope.Signatures[0].Sig = base64.StdEncoding.EncodeToString([]byte("forged"))
+	if rec := post(envelope); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected forged signature to be refused, got %d", rec.Code)
+	}
+	foreign.PredicateType = "https://spdx.dev/Document"
+	if rec := post(foreign); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected other predicates to be refused, got %d", rec.Code)
+	}
+}
+
+func TestGateBaseline(t *testing.T) {
+	db = setupTestDB()
+
+	result := func(rule string, uri string, line int, text string) string {
+		return fmt.Sprintf(`{"ruleId": %q, "level": "warning", "message": {"text": %q},
+			"locations": [{"physicalLocation": {"artifactLocation": {"uri": %q}, "region": {"startLine": %d}}}]}`, rule, text, uri, line)
+	}
+	collectTool := func(id string, ref string, tool string, results ...string) {
+		report := `{"runs": [{"tool": {"driver": {"name": "` + tool + `"}}, "results": [` + strings.Join(results, ",") + `]}]}`
+		payload, _ := json.Marshal(shared.CollectMessageBody{
+			Artefact: shared.ProductMessage{Id: id, Type: shared.ArtefactTypeGit},
+			CI:       &shared.CIContext{Project: "gate/project", Ref: ref},
+			Reports:  map[string]string{"scanner.sarif": base64.StdEncoding.EncodeToString([]byte(report))},
+		})
+		rec := httptest.NewRecorder()
+		server.CollectHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collect", bytes.NewReader(payload)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+	}
+	collect := func(id string, ref string, results ...string) { collectTool(id, ref, "scanner", results...) }
+	gate := func(query string) (int, server.GateResponse) {
+		rec := httptest.NewRecorder()
+		server.GWHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/gw?"+query, nil))
+		var response server.GateResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return rec.Code, response
+	}
+
+	collect("gate-main-old", "main", result("G101", "main.go", 10, "old"))
+	collect("gate-main", "main", result("G101", "main.go", 10, "hardcoded"), result("G104", "util.go", 5, "unchecked"))
+	// The finding moved down the file is the same one, a new one is introduced and another one is fixed
+	collect("gate-mr", "feature", result("G101", "main.go", 14, "hardcoded"), result("G201", "main.go", 20, "sql"))
+
+	code, response := gate("product=gate-mr&baseline=refs/heads/main")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if response.BaselineID != "gate-main" {
+		t.Errorf("Expected the latest product of the branch as baseline, got '%s'", response.BaselineID)
+	}
+	if len(response.Introduced) != 1 || response.Introduced[0].VulnerabilityID != "G201" {
+		t.Errorf("Expected G201 introduced, got %+v", response.Introduced)
+	}
+	if len(response.Fixed) != 1 || response.Fixed[0].VulnerabilityID != "G104" {
+		t.Errorf("Expected G104 fixed, got %+v", response.Fixed)
+	}
+	if len(response.Unchanged) != 1 || response.Unchanged[0].LocationHash != "main.go(14)" {
+		t.Errorf("Expected G101 unchanged as found in the product, got %+v", response.Unchanged)
+	}
+
+	_, response = gate("product=gate-mr&baseline=gate-main-old")
+	if response.BaselineID != "gate-main-old" || len(response.Introduced) != 2 || len(response.Fixed) != 1 {
+		t.Errorf("Expected comparison with the product given as baseline, got %+v", response)
+	}
+	_, response = gate("product=gate-mr")
+	if response.BaselineID != "" || len(response.Introduced) != 2 {
+		t.Errorf("Expected every finding introduced without baseline, got %+v", response)
+	}
+
+	// The fingerprint the tool gives wins over the message, and the same rule of another tool is another finding
+	fingerprinted := func(rule string, uri string, text string, fingerprint string) string {
+		return fmt.Sprintf(`{"ruleId": %q, "level": "error", "message": {"text": %q}, "partialFingerprints": {"primaryLocationLineHash": %q},
+			"locations": [{"physicalLocation": {"artifactLocation": {"uri": %q}, "region": {"startLine": 1}}}]}`, rule, text, fingerprint, uri)
+	}
+	collect("gate-fp-main", "fp-main", fingerprinted("G101", "main.go", "credential in 'password'", "f00d:1"))
+	collectTool("gate-fp-main", "fp-main", "linter", result("G104", "util.go", 5, "unchecked"))
+	collect("gate-fp-mr", "fp-feature", fingerprinted("G101", "main.go", "credential in 'passwd'", "f00d:1"), result("G104", "util.go", 5, "unchecked"))
+	_, response = gate("product=gate-fp-mr&baseline=gate-fp-main")
+	if len(response.Unchanged) != 1 || response.Unchanged[0].VulnerabilityID != "G101" ||
+		len(response.Introduced) != 1 || response.Introduced[0].VulnerabilityID != "G104" || len(response.Fixed) != 1 {
+		t.Errorf("Expected the fingerprinted finding unchanged and the one of another tool introduced, got %+v", response)
+	}
+
+	if code, _ := gate("product=gate-mr&baseline=release"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown baseline, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	db = setupTestDB()
+
+	for _, name := range []string{"delta", "alpha", "echo", "charlie", "bravo"} {
+		db.Create(&server.Product{ProductID: "page-" + name, Name: name, Project: "b4bay/pages"})
+	}
+
+	list := func(query string) ([]server.ProductResponse, string, int) {
+		rec := httptest.NewRecorder()
+		server.UIProductHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/product?"+query, nil))
+		var products []server.ProductResponse
+		json.NewDecoder(rec.Body).Decode(&products)
+		return products, rec.Header().Get("X-Next-Cursor"), rec.Code
+	}
+
+	// Pages follow each other by cursor without gaps or repeats
+	var names []string
+	query := url.Values{"project": {"b4bay/pages"}, "sort": {"-name"}, "limit": {"2"}}
+	for pages := 0; ; pages++ {
+		products, cursor, code := list(query.Encode())
+		if code != http.StatusOK || pages > 3 {
+			t.Fatalf("Expected 3 pages, got status %d on page %d", code, pages+1)
+		}
+		for _, product := range products {
+			names = append(names, product.Name)
+		}
+		if cursor == "" {
+			break
+		}
+		query.Set("cursor", cursor)
+	}
+	if strings.Join(names, ",") != "echo,delta,charlie,bravo,alpha" {
+		t.Errorf("Expected products by name descending, got %v", names)
+	}
+
+	// Without a limit nor a cursor the list is not paginated, as for clients made before pagination
+	for i := 0; i <= server.DefaultPageLimit; i++ {
+		db.Create(&server.Product{ProductID: fmt.Sprintf("page-all-%d", i), Project: "b4bay/pages-all"})
+	}
+	if products, cursor, _ := list("project=b4bay/pages-all"); len(products) != server.DefaultPageLimit+1 || cursor != "" {
+		t.Errorf("Expected every product without a cursor, got %d and '%s'", len(products), cursor)
+	}
+
+	// A cursor is bound to its sort
+	query.Set("sort", "name")
+	if _, _, code := list(query.Encode()); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a cursor of another sort, got %d", http.StatusBadRequest, code)
+	}
+	if _, _, code := list("sort=author"); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unsupported sort, got %d", http.StatusBadRequest, code)
+	}
+	if products, _, _ := list("project=b4bay/pages&since=2999-01-01"); len(products) != 0 {
+		t.Errorf("Expected no products created in the future, got %+v", products)
+	}
+
+	// Engagements tell the length of the report without returning it
+	db.Create(&server.Engagement{ProductID: "page-alpha", Tool: "gosec", RawReport: "cmVwb3J0"})
+	rec := httptest.NewRecorder()
+	server.UIEngagementHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/engagement?product=page-alpha&tool=gosec", nil))
+	var engagements []server.EngagementResponse
+	json.NewDecoder(rec.Body).Decode(&engagements)
+	if len(engagements) != 1 || engagements[0].ReportLength != 8 || engagements[0].ProductID != "page-alpha" {
+		t.Errorf("Expected the engagement with its report length, got %+v", engagements)
+	}
+}
+
+func TestProductDetail(t *testing.T) {
+	db = setupTestDB()
+
+	collect, _ := json.Marshal(shared.CollectMessageBody{
+		CI:       &shared.CIContext{Provider: "gitlab", Project: "b4bay/detail", Ref: "main", Revision: "cafe", URL: "https://gitlab.com/b4bay/detail/-/jobs/1"},
+		Artefact: shared.ProductMessage{Id: "detail-bin", Name: "detail", Type: shared.ArtefactTypeBin},
+		Reports:  map[string]string{"gosec.sarif": sarif.MockGosecReport},
+	})
+	rec := httptest.NewRecorder()
+	server.CollectHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/collect", bytes.NewReader(collect)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	for _, body := range []shared.OriginMessageBody{
+		{Product: shared.ProductMessage{Id: "detail-bin"}, Origins: []shared.ProductMessage{{Id: "detail-src", Type: shared.ArtefactTypeGit}}, ProductionMethod: shared.ProductionMethodCompile},
+		{Product: shared.ProductMessage{Id: "pkg:oci/detail@sha256:ff", Type: shared.ArtefactTypeImage}, Origins: []shared.ProductMessage{{Id: "detail-bin"}}, ProductionMethod: shared.ProductionMethodContainerize},
+	} {
+		payload, _ := json.Marshal(body)
+		server.OriginHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/origin", bytes.NewReader(payload)))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2/products/{productId...}", server.ProductDetailHandler)
+	get := func(id string) (server.ProductDetailResponse, int) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/products/"+id, nil))
+		var detail server.ProductDetailResponse
+		json.NewDecoder(rec.Body).Decode(&detail)
+		return detail, rec.Code
+	}
+
+	detail, code := get("detail-bin")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if detail.ProductID != "detail-bin" || detail.Name != "detail" || detail.CI.Revision != "cafe" || detail.CI.Project != "b4bay/detail" ||
+		detail.CI.URL != "https://gitlab.com/b4bay/detail/-/jobs/1" {
+		t.Errorf("Expected the product with its CI context, got %+v", detail)
+	}
+	if len(detail.Engagements) != 1 || detail.Engagements[0].Tool == "" || detail.Engagements[0].ReportLength == 0 {
+		t.Errorf("Expected the latest engagement of gosec, got %+v", detail.Engagements)
+	}
+
+	var count int64
+	db.Model(&server.Vulnerability{}).Where("product_id = ?", "detail-bin").Count(&count)
+	var byLevel int
+	for _, n := range detail.Findings.ByLevel {
+		byLevel += n
+	}
+	if count == 0 || detail.Findings.Total != int(count) || byLevel != int(count) || detail.Findings.ByStatus["open"] != int(count) {
+		t.Errorf("Expected %d open findings, got %+v", count, detail.Findings)
+	}
+	if len(detail.Origins) != 1 || detail.Origins[0].ProductID != "detail-src" || detail.Origins[0].Method != shared.ProductionMethodCompile ||
+		len(detail.Products) != 1 || detail.Products[0].ProductID != "pkg:oci/detail@sha256:ff" {
+		t.Errorf("Expected the direct origin and derived product, got %+v and %+v", detail.Origins, detail.Products)
+	}
+
+	// Ids with slashes are taken as they are
+	if detail, code := get("pkg:oci/detail@sha256:ff"); code != http.StatusOK || len(detail.Origins) != 1 || detail.Origins[0].ProductID != "detail-bin" {
+		t.Errorf("Expected the image by its purl id, got %d: %+v", code, detail)
+	}
+	if _, code := get("detail-unknown"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestBlastRadius(t *testing.T) {
+	db = setupTestDB()
+
+	// lib package -> source tree -> binary -> image, and a tool built from the source tree too
+	for _, body := range []shared.OriginMessageBody{
+		{Product: shared.ProductMessage{Id: "blast-src", Type: shared.ArtefactTypeGit},
+			Origins: []shared.ProductMessage{{Id: "pkg:npm/blast-lib@1.0.0?arch=x64", Type: shared.ArtefactTypePackage}}, ProductionMethod: shared.ProductionMethodComponent},
+		{Product: shared.ProductMessage{Id: "blast-bin", Type: shared.ArtefactTypeBin},
+			Origins: []shared.ProductMessage{{Id: "blast-src"}}, ProductionMethod: shared.ProductionMethodCompile},
+		{Product: shared.ProductMessage{Id: "blast-tool", Type: shared.ArtefactTypeBin},
+			Origins: []shared.ProductMessage{{Id: "blast-src"}}, ProductionMethod: shared.ProductionMethodCompile},
+		{Product: shared.ProductMessage{Id: "blast-image", Type: shared.ArtefactTypeImage},
+			Origins: []shared.ProductMessage{{Id: "blast-bin"}}, ProductionMethod: shared.ProductionMethodContainerize},
+	} {
+		body.CI = &shared.CIContext{Project: "b4bay/blast", Ref: "main"}
+		payload, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		server.OriginHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/origin", bytes.NewReader(payload)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+	}
+	finding := server.Vulnerability{VulnerabilityID: "GHSA-blast", LocationHash: "package.json(1)", ProductID: "blast-bin", CVE: "CVE-2099-0001", EngagementID: 1}
+	db.Create(&finding)
+
+	blast := func(query string) (server.BlastRadiusResponse, int) {
+		rec := httptest.NewRecorder()
+		server.UIBlastRadiusHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ui/blast-radius?"+query, nil))
+		var radius server.BlastRadiusResponse
+		json.NewDecoder(rec.Body).Decode(&radius)
+		return radius, rec.Code
+	}
+	reached := func(radius server.BlastRadiusResponse) map[string]server.BlastProductResponse {
+		products := map[string]server.BlastProductResponse{}
+		for _, product := range radius.Products {
+			products[product.ProductID] = product
+		}
+		return products
+	}
+
+	// Any version of the package, walked down to every product made of it
+	radius, code := blast("purl=" + url.QueryEscape("pkg:npm/blast-lib"))
+	products := reached(radius)
+	if code != http.StatusOK || len(radius.Affected) != 1 || len(products) != 4 {
+		t.Fatalf("Expected the package and 4 products made of it, got %d: %+v", code, radius)
+	}
+	image := products["blast-image"]
+	var path []string
+	for _, step := range image.Path {
+		path = append(path, step.ProductID+":"+string(step.Method))
+	}
+	if !image.Leaf || image.Project != "b4bay/blast" || strings.Join(path, ",") != "pkg:npm/blast-lib@1.0.0?arch=x64:,blast-src:component,blast-bin:compile,blast-image:containerize" {
+		t.Errorf("Expected the image shipped with the path to it, got %+v", image)
+	}
+	if products["blast-bin"].Leaf || !products["blast-tool"].Leaf {
+		t.Errorf("Expected the binary made into the image and the tool shipped, got %+v", products)
+	}
+
+	// A CVE and a finding start from the product it was found in
+	for _, query := range []string{"cve=cve-2099-0001", fmt.Sprintf("finding=%d", finding.ID)} {
+		radius, code = blast(query)
+		if products := reached(radius); code != http.StatusOK || len(radius.Affected) != 1 || radius.Affected[0].ProductID != "blast-bin" ||
+			len(products) != 1 || products["blast-image"].ProductID == "" {
+			t.Errorf("Expected the image made of the binary for %s, got %d: %+v", query, code, radius)
+		}
+	}
+
+	// A chain longer than the lineage depth is walked to its end
+	for i := 1; i <= server.MaxLineageDepth+2; i++ {
+		payload, _ := json.Marshal(shared.OriginMessageBody{
+			Product:          shared.ProductMessage{Id: fmt.Sprintf("blast-chain-%d", i), Type: shared.ArtefactTypeBin},
+			Origins:          []shared.ProductMessage{{Id: fmt.Sprintf("blast-chain-%d", i-1), Type: shared.ArtefactTypeBin}},
+			ProductionMethod: shared.ProductionMethodPack,
+		})
+		server.OriginHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/origin", bytes.NewReader(payload)))
+	}
+	db.Create(&server.Vulnerability{VulnerabilityID: "GHSA-chain", LocationHash: "chain(1)", ProductID: "blast-chain-0", CVE: "CVE-2099-0002", EngagementID: 1})
+	radius, _ = blast("cve=CVE-2099-0002")
+	if products := reached(radius); len(products) != server.MaxLineageDepth+2 || !products[fmt.Sprintf("blast-chain-%d", server.MaxLineageDepth+2)].Leaf {
+		t.Errorf("Expected the whole chain, got %+v", radius)
+	}
+
+	if _, code := blast("cve=CVE-2099-0001&finding=1"); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for two subjects, got %d", http.StatusBadRequest, code)
+	}
+	if _, code := blast("finding=999999"); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown finding, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	db = setupTestDB()
+
+	for _, body := range []shared.OriginMessageBody{
+		{Product: shared.ProductMessage{Id: "gql-bin", Type: shared.ArtefactTypeBin},
+			Origins: []shared.ProductMessage{{Id: "gql-src", Type: shared.ArtefactTypeGit}}, ProductionMethod: shared.ProductionMethodCompile},
+		{Product: shared.ProductMessage{Id: "gql-image", Type: shared.ArtefactTypeImage},
+			Origins: []shared.ProductMessage{{Id: "gql-bin"}}, ProductionMethod: shared.ProductionMethodContainerize},
+	} {
+		body.CI = &shared.CIContext{Project: "b4bay/graphql", Ref: "main"}
+		payload, _ := json.Marshal(body)
+		rec := httptest.NewRecorder()
+		server.OriginHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/origin", bytes.NewReader(payload)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+	}
+	open := server.Vulnerability{VulnerabilityID: "gql-rule-1", LocationHash: "main.go(1)", ProductID: "gql-src", Level: "error"}
+	triaged := server.Vulnerability{VulnerabilityID: "gql-rule-2", LocationHash: "main.go(2)", ProductID: "gql-src", Level: "warning"}
+	db.Create(&open)
+	db.Create(&triaged)
+	db.Create(&server.Status{VulnerabilityID: fmt.Sprint(triaged.ID), Kind: shared.StatusFalsePositive, Reason: "test code", Author: "alice"})
+
+	query := func(request graphql.Request) (map[string]interface{}, []graphql.Error, int) {
+		payload, _ := json.Marshal(request)
+		rec := httptest.NewRecorder()
+		server.GraphQLHandler(rec, httptest.NewRequest(http.MethodPost, "/api/v1/graphql", bytes.NewReader(payload)))
+		var response struct {
+			Data   map[string]interface{} `json:"data"`
+			Errors []graphql.Error        `json:"errors"`
+		}
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response.Data, response.Errors, rec.Code
+	}
+
+	// project -> products -> lineage -> findings -> statuses, in one query
+	data, errs, code := query(graphql.Request{
+		Query: `query Nested($project: String!) {
+			project(name: $project) {
+				name
+				products(type: "image") {
+					id
+					...lineage
+				}
+			}
+		}
+		fragment lineage on Product {
+			origins { method origin { id origins { origin { id findings(first: 5) { ruleId status statuses(first: 3) { kind reason } } } } } }
+		}`,
+		Variables: map[string]interface{}{"project": "b4bay/graphql"},
+	})
+	if code != http.StatusOK || len(errs) != 0 {
+		t.Fatalf("Expected status %d, got %d: %+v", http.StatusOK, code, errs)
+	}
+	products := data["project"].(map[string]interface{})["products"].([]interface{})
+	if len(products) != 1 || products[0].(map[string]interface{})["id"] != "gql-image" {
+		t.Fatalf("Expected the image of the project, got %+v", products)
+	}
+	bin := products[0].(map[string]interface{})["origins"].([]interface{})[0].(map[string]interface{})
+	src := bin["origin"].(map[string]interface{})["origins"].([]interface{})[0].(map[string]interface{})["origin"].(map[string]interface{})
+	findings := src["findings"].([]interface{})
+	if bin["method"] != "containerize" || src["id"] != "gql-src" || len(findings) != 2 {
+		t.Fatalf("Expected the findings of the source tree through the binary, got %+v", bin)
+	}
+	first, second := findings[0].(map[string]interface{}), findings[1].(map[string]interface{})
+	if first["status"] != "open" || len(first["statuses"].([]interface{})) != 0 {
+		t.Errorf("Expected the first finding open, got %+v", first)
+	}
+	statuses := second["statuses"].([]interface{})
+	if second["status"] != "false_positive" || len(statuses) != 1 || statuses[0].(map[string]interface{})["reason"] != "test code" {
+		t.Errorf("Expected the second finding a false positive, got %+v", second)
+	}
+
+	// Aliases and filters
+	data, errs, _ = query(graphql.Request{Query: `{ open: findings(product: "gql-src", status: "open") { ruleId } all: findings(product: "gql-src", first: 1) { ruleId } }`})
+	if len(errs) != 0 || len(data["open"].([]interface{})) != 1 || len(data["all"].([]interface{})) != 1 {
+		t.Errorf("Expected one open finding and the first one, got %+v %+v", data, errs)
+	}
+
+	for name, request := range map[string]graphql.Request{
+		"mutation":      {Query: `mutation { products { id } }`},
+		"unknown field": {Query: `{ products { secret } }`},
+		"over the cost": {Query: `{ products(first: 1000) { origins(first: 1000) { origin { id } } } }`},
+		"missing var":   {Query: `query ($name: String!) { project(name: $name) { name } }`},
+		"too deep":      {Query: "{" + strings.Repeat("a {", 100000)},
+		"too deep list": {Query: "{ products(type: " + strings.Repeat("[", 100000) + ") { id } }"},
+		"too big":       {Query: "{ products { id } }" + strings.Repeat(" ", server.MaxGraphQLBody)},
+	} {
+		if _, errs, code = query(request); code != http.StatusBadRequest || len(errs) != 1 {
+			t.Errorf("Expected the %s rejected, got %d: %+v", name, code, errs)
+		}
+	}
+}
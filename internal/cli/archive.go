@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ArchiveMember is a file packed into an archive, identified the same way as IdBin does
+type ArchiveMember struct {
+	Path    string
+	Id      string
+	Digests map[string]string
+}
+
+var archiveExtensions = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tar.xz", ".tar.zst", ".zip", ".jar", ".war", ".ear", ".whl", ".deb"}
+
+// IsArchive tells by the file name whether ArchiveMembers can look inside the file
+func IsArchive(path string) bool {
+	name := strings.ToLower(path)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveMembers hashes every non-empty regular file of the archive
+func ArchiveMembers(path string) ([]ArchiveMember, error) {
+	var members []ArchiveMember
+	collect := func(name string, size int64, r io.Reader) (bool, error) {
+		if size == 0 {
+			return true, nil
+		}
+		digests, err := digestReader(r)
+		if err != nil {
+			return false, fmt.Errorf("failed to hash '%s': %w", name, err)
+		}
+		members = append(members, ArchiveMember{Path: name, Id: digests["sha1"], Digests: digests})
+		return true, nil
+	}
+
+	var err error
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".jar"), strings.HasSuffix(name, ".war"),
+		strings.HasSuffix(name, ".ear"), strings.HasSuffix(name, ".whl"):
+		err = walkZip(path, collect)
+	case strings.HasSuffix(name, ".deb"):
+		err = walkDeb(path, collect)
+	default:
+		err = walkTar(path, collect)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// walkTar calls fn for every regular file of the tarball until fn returns false, compression is detected by content
+func walkTar(path string, fn func(name string, size int64, r io.Reader) (bool, error)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return walkTarReader(file, fn)
+}
+
+func walkTarReader(r io.Reader, fn func(name string, size int64, r io.Reader) (bool, error)) error {
+	dr, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	tr := tar.NewReader(dr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		more, err := fn(cleanArchiveName(header.Name), header.Size, tr)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+func walkZip(path string, fn func(name string, size int64, r io.Reader) (bool, error)) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !f.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		more, err := fn(cleanArchiveName(f.Name), int64(f.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkDeb looks into the data tarball of a Debian package, which is an ar archive
+func walkDeb(path string, fn func(name string, size int64, r io.Reader) (bool, error)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "!<arch>\n" {
+		return errors.New("not a debian package")
+	}
+
+	header := make([]byte, 60)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return errors.New("debian package has no data archive")
+		} else if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid debian package: %w", err)
+		}
+
+		if strings.HasPrefix(name, "data.tar") {
+			return walkTarReader(io.LimitReader(r, size), fn)
+		}
+
+		// Members are aligned to even offsets
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return err
+		}
+	}
+}
+
+// decompress detects gzip, bzip2, xz and zstd by magic bytes, anything else is returned as is.
+// Closing the reader releases the decoder, not the underlying reader.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+func cleanArchiveName(name string) string {
+	return strings.TrimPrefix(path.Clean(name), "./")
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var BlastColumns = []string{"PRODUCT", "NAME", "TYPE", "PROJECT", "REF", "LEAF", "PATH"}
+
+// BlastRadius is what is made of the affected products, as the server walks it
+type BlastRadius struct {
+	Affected []BlastProduct `json:"affected"`
+	Products []BlastProduct `json:"products"`
+}
+
+// BlastProduct is a product reached from an affected one, Leaf ones are shipped as they are
+type BlastProduct struct {
+	ProductID string      `json:"product_id"`
+	Name      string      `json:"name"`
+	Type      string      `json:"type"`
+	Project   string      `json:"project"`
+	Ref       string      `json:"ref,omitempty"`
+	Leaf      bool        `json:"leaf"`
+	Path      []BlastStep `json:"path"`
+}
+
+type BlastStep struct {
+	ProductID string `json:"product_id"`
+	Method    string `json:"method,omitempty"`
+}
+
+var cvePattern = regexp.MustCompile(`(?i)^CVE-\d{4}-\d+$`)
+
+// BlastQuery tells what the subject is: a CVE, a package URL or the id of a finding
+func BlastQuery(subject string) (url.Values, error) {
+	query := url.Values{}
+	switch {
+	case cvePattern.MatchString(subject):
+		query.Set("cve", strings.ToUpper(subject))
+	case strings.HasPrefix(subject, "pkg:"):
+		query.Set("purl", subject)
+	default:
+		if _, err := strconv.ParseUint(subject, 10, 64); err != nil {
+			return nil, fmt.Errorf("'%s' is neither a CVE, a purl nor a finding id", subject)
+		}
+		query.Set("finding", subject)
+	}
+	return query, nil
+}
+
+// Blast fetches the blast radius of the subject, see BlastQuery
+func Blast(client ASPMClientInterface, subject string) (BlastRadius, error) {
+	var radius BlastRadius
+	query, err := BlastQuery(subject)
+	if err != nil {
+		return radius, err
+	}
+	return radius, client.Get("/ui/blast-radius", query, &radius)
+}
+
+func (p BlastProduct) Row() []string {
+	var path []string
+	for _, step := range p.Path {
+		if step.Method != "" {
+			path = append(path, "-"+step.Method+"->")
+		}
+		path = append(path, step.ProductID)
+	}
+	return []string{p.ProductID, p.Name, p.Type, p.Project, p.Ref, strconv.FormatBool(p.Leaf), strings.Join(path, " ")}
+}
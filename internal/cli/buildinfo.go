@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"debug/buildinfo"
+	"strings"
+)
+
+// GoBuild is what the Go toolchain records about a binary when building it
+type GoBuild struct {
+	GoVersion string
+	Module    string // Main module path
+	Revision  string // Commit the binary was built from, empty when built outside of a repository
+	Modified  bool   // Built from a tree with uncommitted changes
+	Modules   []GoModule
+}
+
+// GoModule is a dependency compiled into the binary, replacements already applied
+type GoModule struct {
+	Path    string
+	Version string
+	Sum     string
+}
+
+// Purl identifies the module the way SBOMs do, e.g. pkg:golang/gopkg.in/yaml.v3@v3.0.1
+func (m GoModule) Purl() string {
+	return newPurl("golang", m.Path, m.Version)
+}
+
+// IsGoBinary tells whether the file is a Go binary with build info
+func IsGoBinary(path string) bool {
+	_, err := buildinfo.ReadFile(path)
+	return err == nil
+}
+
+// ReadGoBuild reads the build info embedded into Go binaries, other files are an error
+func ReadGoBuild(path string) (*GoBuild, error) {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	build := &GoBuild{
+		GoVersion: info.GoVersion,
+		Module:    info.Main.Path,
+	}
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		// Local replacements have no version and nothing to identify them by
+		if dep.Version == "" || strings.HasPrefix(dep.Path, ".") || strings.HasPrefix(dep.Path, "/") {
+			continue
+		}
+		build.Modules = append(build.Modules, GoModule{Path: dep.Path, Version: dep.Version, Sum: dep.Sum})
+	}
+
+	return build, nil
+}
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"github.com/b4bay/aspm/internal/shared"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// LocalProvider is the fallback outside of CI, it reads the context from the git repository directly
+type LocalProvider struct {
+	Dir string
+}
+
+func (p LocalProvider) Name() string {
+	return "local"
+}
+
+func (p LocalProvider) Detect(env map[string]string) bool {
+	return true
+}
+
+func (p LocalProvider) Context(env map[string]string) shared.CIContext {
+	c := shared.CIContext{
+		Provider: p.Name(),
+		Project:  shared.ProjectFromURL(p.git("config", "--get", "remote.origin.url")),
+		Ref:      p.git("symbolic-ref", "--short", "-q", "HEAD"),
+		Revision: p.git("rev-parse", "HEAD"),
+		Author:   p.git("log", "-1", "--pretty=format:%an"),
+	}
+
+	// Detached HEAD, e.g. a tag checkout
+	if c.Ref == "" {
+		c.Ref = p.git("describe", "--tags", "--exact-match")
+	}
+
+	c.Worker, _ = os.Hostname()
+
+	return c
+}
+
+func (p LocalProvider) git(args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = p.Dir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// GetCIContext reads the context of the pipeline running the CLI, or of the git repository in dir outside of CI
+func GetCIContext(dir string) shared.CIContext {
+	var env = map[string]string{}
+	for _, e := range os.Environ() {
+		pair := strings.SplitN(e, "=", 2)
+		env[pair[0]] = pair[1]
+	}
+
+	if p := shared.DetectCIProvider(env); p != nil {
+		return p.Context(env)
+	}
+
+	return LocalProvider{Dir: dir}.Context(env)
+}
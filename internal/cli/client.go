@@ -4,25 +4,56 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultMaxRetries = 3
+	DefaultBaseDelay  = 500 * time.Millisecond
+	DefaultMaxDelay   = 10 * time.Second
 )
 
 type ASPMClientInterface interface {
 	Post(string, interface{}) error
+	// Get decodes the JSON answer of the endpoint into the result
+	Get(string, url.Values, interface{}) error
+}
+
+// PagedClient is a client which can tell the cursor of the next page of a list endpoint, see List
+type PagedClient interface {
+	// GetPage is Get returning the cursor of the next page, empty on the last one
+	GetPage(string, url.Values, interface{}) (string, error)
 }
 
 type ASPMClient struct {
-	serverURL string
-	apiKey    string
+	serverURL  string
+	apiKey     string
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
 }
 
 func NewASPMClient(serverUrl string, apiKey string) *ASPMClient {
 	return &ASPMClient{
-		serverURL: serverUrl,
-		apiKey:    apiKey,
+		serverURL:  serverUrl,
+		apiKey:     apiKey,
+		maxRetries: DefaultMaxRetries,
+		baseDelay:  DefaultBaseDelay,
+		maxDelay:   DefaultMaxDelay,
 	}
 }
 
+// SetRetryPolicy changes how many times a failed request is retried and how long to wait between attempts
+func (c *ASPMClient) SetRetryPolicy(maxRetries int, baseDelay time.Duration, maxDelay time.Duration) {
+	c.maxRetries = maxRetries
+	c.baseDelay = baseDelay
+	c.maxDelay = maxDelay
+}
+
 func (c *ASPMClient) Post(endpoint string, data interface{}) error {
 	// Marshal the data into JSON
 	jsonData, err := json.Marshal(data)
@@ -30,29 +61,102 @@ func (c *ASPMClient) Post(endpoint string, data interface{}) error {
 		return fmt.Errorf("failed to marshal data: %w", err)
 	}
 
+	return c.retry(func() (bool, error) {
+		return c.send(http.MethodPost, endpoint, jsonData, nil, nil)
+	})
+}
+
+func (c *ASPMClient) Get(endpoint string, query url.Values, result interface{}) error {
+	_, err := c.GetPage(endpoint, query, result)
+	return err
+}
+
+func (c *ASPMClient) GetPage(endpoint string, query url.Values, result interface{}) (string, error) {
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	header := http.Header{}
+	err := c.retry(func() (bool, error) {
+		return c.send(http.MethodGet, endpoint, nil, result, header)
+	})
+	return header.Get("X-Next-Cursor"), err
+}
+
+// retry repeats the attempt while it fails with a retryable error
+func (c *ASPMClient) retry(attempt func() (bool, error)) error {
+	for i := 0; ; i++ {
+		retryable, err := attempt()
+		if err == nil || !retryable || i >= c.maxRetries {
+			return err
+		}
+
+		time.Sleep(c.backoff(i))
+	}
+}
+
+// send makes a single attempt and reports whether a failure is worth retrying, the answer is decoded into result unless nil
+// and its headers are copied into header unless nil
+func (c *ASPMClient) send(method string, endpoint string, jsonData []byte, result interface{}, header http.Header) (bool, error) {
 	// Create an HTTP request
 	url := fmt.Sprintf("%s%s", c.serverURL, endpoint)
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	var body io.Reader
+	if jsonData != nil {
+		body = bytes.NewBuffer(jsonData)
+	}
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return false, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
-	req.Header.Set("Content-Type", "application/json")
+	if jsonData != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
 
 	// Execute the HTTP request
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return true, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Check for HTTP errors
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned error: %s", resp.Status)
+		retryable := resp.StatusCode >= http.StatusInternalServerError ||
+			resp.StatusCode == http.StatusTooManyRequests ||
+			resp.StatusCode == http.StatusRequestTimeout
+		return retryable, fmt.Errorf("server returned error: %s", resp.Status)
+	}
+
+	if header != nil {
+		for key, values := range resp.Header {
+			header[key] = values
+		}
 	}
 
-	return nil
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return false, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return false, nil
+}
+
+// backoff returns the delay before the next attempt: exponential growth capped by maxDelay,
+// with half of it randomized, so clients failed together do not retry together
+func (c *ASPMClient) backoff(attempt int) time.Duration {
+	delay := c.baseDelay << attempt
+	if delay <= 0 || delay > c.maxDelay {
+		delay = c.maxDelay
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half)
 }
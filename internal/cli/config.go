@@ -0,0 +1,337 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/b4bay/aspm/internal/shared"
+	"gopkg.in/yaml.v3"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ConfigFileName     = ".aspm.yaml"
+	DefaultProfileName = "default"
+	DefaultSpool       = ".aspm-spool"
+	DefaultKeyEnv      = "ASPM_SERVER_KEY"
+)
+
+// Credential tells where the server key comes from, only one source is expected
+type Credential struct {
+	Env     string `yaml:"env,omitempty"`
+	File    string `yaml:"file,omitempty"`
+	Command string `yaml:"command,omitempty"`
+}
+
+// Key reads the server key from the source
+func (c Credential) Key() (string, error) {
+	switch {
+	case c.Command != "":
+		cmd := exec.Command("sh", "-c", c.Command)
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("credential command failed: %w", err)
+		}
+		return strings.TrimSpace(string(output)), nil
+	case c.File != "":
+		content, err := os.ReadFile(expandHome(c.File))
+		if err != nil {
+			return "", fmt.Errorf("credential file is not readable: %w", err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	case c.Env != "":
+		return os.Getenv(c.Env), nil
+	default:
+		return "", nil
+	}
+}
+
+// Gate holds options of the 'gw' mode
+type Gate struct {
+	Level  string `yaml:"level,omitempty"`  // Minimal finding level which breaks the gate
+	Max    int    `yaml:"max,omitempty"`    // Number of breaking findings tolerated
+	Signed bool   `yaml:"signed,omitempty"` // Evidence of the product must be signed with a registered key
+}
+
+// Profile is a named set of CLI settings, empty values are taken from a less specific source
+type Profile struct {
+	Server       string                   `yaml:"server,omitempty"`
+	Credential   Credential               `yaml:"credential,omitempty"`
+	Method       shared.ProductionMethod  `yaml:"method,omitempty"`
+	Reports      []string                 `yaml:"reports,omitempty"`
+	Ignore       []string                 `yaml:"ignore,omitempty"` // Patterns skipped when hashing dir artefacts
+	Environment  shared.EnvironmentFilter `yaml:"environment,omitempty"`
+	Gate         Gate                     `yaml:"gate,omitempty"`
+	Spool        string                   `yaml:"spool,omitempty"`
+	FailOnUpload *bool                    `yaml:"fail_on_upload,omitempty"`
+	SigningKey   string                   `yaml:"signing_key,omitempty"` // Private key file collect and origin payloads are signed with
+}
+
+// ConfigFile is the content of .aspm.yaml
+type ConfigFile struct {
+	Profile  string             `yaml:"profile,omitempty"` // Profile used when none is asked for
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Config is the effective configuration of the CLI
+type Config struct {
+	Profile
+	Name  string   // Name of the profile in use
+	Files []string // Configuration files read, least specific first
+
+	// Repository configuration file the server is taken from, empty when set or confirmed by the user
+	ServerFrom string
+}
+
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file ConfigFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid configuration file '%s': %w", path, err)
+	}
+
+	for name, profile := range file.Profiles {
+		if profile.Method != "" && !shared.IsValidProductionMethod(profile.Method) {
+			return nil, fmt.Errorf("invalid configuration file '%s': profile '%s' has invalid method '%s'", path, name, profile.Method)
+		}
+	}
+
+	return &file, nil
+}
+
+// LoadConfig resolves the effective configuration of the profile, with precedence env > repo file > user file.
+// Empty profile name means the one set by ASPM_PROFILE, then by configuration files, then the default one.
+// Flags are applied on top of it by the caller.
+func LoadConfig(profileName string) (Config, error) {
+	var config Config
+	var files []*ConfigFile
+
+	for _, path := range ConfigFilePaths() {
+		file, err := LoadConfigFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return config, err
+		}
+		files = append(files, file)
+		config.Files = append(config.Files, path)
+	}
+
+	if profileName == "" {
+		profileName = os.Getenv("ASPM_PROFILE")
+	}
+	explicit := profileName != ""
+	for _, file := range files {
+		if !explicit && file.Profile != "" {
+			profileName = file.Profile
+		}
+	}
+	if profileName == "" {
+		profileName = DefaultProfileName
+	}
+	config.Name = profileName
+
+	// Built-in defaults
+	config.Profile = Profile{
+		Credential: Credential{Env: DefaultKeyEnv},
+		Method:     shared.ProductionMethodDefault,
+		Spool:      DefaultSpool,
+	}
+
+	found := false
+	userPath := UserConfigPath()
+	var userServer string
+	for i, file := range files {
+		profile, ok := file.Profiles[profileName]
+		if !ok {
+			continue
+		}
+		found = true
+		if path := config.Files[i]; path != userPath {
+			// A repository is not trusted to run commands, read files or variables of the user
+			if profile.Credential != (Credential{}) {
+				return config, fmt.Errorf("'%s': credential is only accepted from %s or the environment", path, userPath)
+			}
+			if profile.Server != "" {
+				config.ServerFrom = path
+			}
+		} else if profile.Server != "" {
+			userServer = profile.Server
+			config.ServerFrom = ""
+		}
+		config.Profile.overlay(profile)
+	}
+	if explicit && !found {
+		return config, fmt.Errorf("profile '%s' not found in %v", profileName, config.Files)
+	}
+
+	environment := profileFromEnvironment()
+	if environment.Server != "" {
+		userServer = environment.Server
+	}
+	config.Profile.overlay(environment)
+	if config.Server == userServer {
+		config.ServerFrom = ""
+	}
+
+	return config, nil
+}
+
+// CheckServer refuses a server set by a repository configuration unless the user sets the same one,
+// the key of the user is never sent to a server picked by a repository
+func (c Config) CheckServer() error {
+	if c.ServerFrom != "" {
+		return fmt.Errorf("server '%s' is set by '%s', set it in %s, ASPM_SERVER_URL or -server to send the server key to it", c.Server, c.ServerFrom, UserConfigPath())
+	}
+	return nil
+}
+
+// UserConfigPath returns the configuration file of the user, in the home directory
+func UserConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ConfigFileName)
+}
+
+// ConfigFilePaths returns candidate configuration files, least specific first:
+// the user one in the home directory and the repository one found from the working directory up to the git root
+func ConfigFilePaths() []string {
+	var paths []string
+
+	if path := UserConfigPath(); path != "" {
+		paths = append(paths, path)
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return paths
+	}
+	for {
+		candidate := filepath.Join(dir, ConfigFileName)
+		if _, err := os.Stat(candidate); err == nil {
+			if len(paths) == 0 || paths[len(paths)-1] != candidate {
+				paths = append(paths, candidate)
+			}
+			break
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	return paths
+}
+
+func profileFromEnvironment() Profile {
+	var profile Profile
+
+	profile.Server = os.Getenv("ASPM_SERVER_URL")
+	if _, ok := os.LookupEnv(DefaultKeyEnv); ok {
+		profile.Credential = Credential{Env: DefaultKeyEnv}
+	}
+	profile.Spool = os.Getenv("ASPM_SPOOL_DIR")
+	profile.SigningKey = os.Getenv("ASPM_SIGNING_KEY")
+	profile.Environment = shared.NewEnvironmentFilter(os.Getenv("ASPM_ENV_INCLUDE"), os.Getenv("ASPM_ENV_EXCLUDE"))
+
+	return profile
+}
+
+// overlay replaces settings with the ones set in the more specific profile
+func (p *Profile) overlay(o Profile) {
+	if o.Server != "" {
+		p.Server = o.Server
+	}
+	if o.Credential != (Credential{}) {
+		p.Credential = o.Credential
+	}
+	if o.Method != "" {
+		p.Method = o.Method
+	}
+	if len(o.Reports) > 0 {
+		p.Reports = o.Reports
+	}
+	if len(o.Ignore) > 0 {
+		p.Ignore = o.Ignore
+	}
+	if len(o.Environment.Include) > 0 {
+		p.Environment.Include = o.Environment.Include
+	}
+	if len(o.Environment.Exclude) > 0 {
+		p.Environment.Exclude = o.Environment.Exclude
+	}
+	if o.Gate.Level != "" {
+		p.Gate.Level = o.Gate.Level
+	}
+	if o.Gate.Max != 0 {
+		p.Gate.Max = o.Gate.Max
+	}
+	if o.Gate.Signed {
+		p.Gate.Signed = true
+	}
+	if o.Spool != "" {
+		p.Spool = o.Spool
+	}
+	if o.FailOnUpload != nil {
+		p.FailOnUpload = o.FailOnUpload
+	}
+	if o.SigningKey != "" {
+		p.SigningKey = o.SigningKey
+	}
+}
+
+// ReportPaths expands report globs of the profile
+func (p *Profile) ReportPaths() []string {
+	var paths []string
+	for _, pattern := range p.Reports {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			fmt.Printf("Error: invalid report pattern '%s': %v\n", pattern, err)
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+
+	return paths
+}
+
+// String renders the configuration as YAML, the server key itself is never shown
+func (c Config) String() string {
+	content, _ := yaml.Marshal(c.Profile)
+	return fmt.Sprintf("# profile: %s\n# files: %s\n%s", c.Name, strings.Join(c.Files, ", "), content)
+}
+
+// SigningPrivateKey reads the private key payloads are signed with, nil when none is configured
+func (p *Profile) SigningPrivateKey() (ed25519.PrivateKey, error) {
+	if p.SigningKey == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(expandHome(p.SigningKey))
+	if err != nil {
+		return nil, fmt.Errorf("signing key file is not readable: %w", err)
+	}
+	return shared.ParsePrivateKey(content)
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
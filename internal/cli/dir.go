@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const IgnoreFileName = ".aspmignore"
+
+// IdDir returns the Merkle hash of the directory tree, see HashDir
+func IdDir(dir string, ignore []string) (string, error) {
+	id, _, err := HashDir(dir, ignore)
+	return id, err
+}
+
+func NameDir(dir string) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return "", errors.New("not a directory")
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(abs), nil
+}
+
+// HashDir computes a deterministic Merkle hash of the directory tree and SHA-256 of every file in it.
+// A file node is the hash of its content, a directory node is the hash of its sorted "<kind> <hash> <name>" entries,
+// so the same tree gives the same id whatever the file system, timestamps or permissions are.
+// Empty directories do not count. Ignore patterns are applied together with the ones in .aspmignore of the directory.
+func HashDir(dir string, ignore []string) (string, map[string]string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !info.IsDir() {
+		return "", nil, errors.New("not a directory")
+	}
+
+	patterns, err := readIgnoreFile(filepath.Join(dir, IgnoreFileName))
+	if err != nil {
+		return "", nil, err
+	}
+	matcher := ignoreMatcher{patterns: append(append([]string{}, ignore...), patterns...)}
+
+	files := map[string]string{}
+	root, err := hashTree(dir, "", matcher, files)
+	if err != nil {
+		return "", nil, err
+	}
+	if root == "" {
+		return "", nil, errors.New("directory has no files")
+	}
+
+	return root, files, nil
+}
+
+func hashTree(root string, rel string, matcher ignoreMatcher, files map[string]string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+
+	// os.ReadDir sorts by name already, sort again to not depend on it
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var nodes []string
+	for _, e := range entries {
+		entryRel := path.Join(rel, e.Name())
+		if matcher.match(entryRel, e.IsDir()) {
+			continue
+		}
+
+		var kind, hash string
+		switch {
+		case e.IsDir():
+			kind = "dir"
+			if hash, err = hashTree(root, entryRel, matcher, files); err != nil {
+				return "", err
+			}
+			if hash == "" {
+				continue
+			}
+		case e.Type()&os.ModeSymlink != 0:
+			kind = "link"
+			target, err := os.Readlink(filepath.Join(root, filepath.FromSlash(entryRel)))
+			if err != nil {
+				return "", err
+			}
+			hash = hashString(filepath.ToSlash(target))
+		case e.Type().IsRegular():
+			kind = "file"
+			if hash, err = hashFile(filepath.Join(root, filepath.FromSlash(entryRel))); err != nil {
+				return "", err
+			}
+			files[entryRel] = hash
+		default:
+			continue
+		}
+
+		nodes = append(nodes, fmt.Sprintf("%s %s %s\n", kind, hash, e.Name()))
+	}
+
+	if len(nodes) == 0 {
+		return "", nil
+	}
+
+	return hashString(strings.Join(nodes, "")), nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func readIgnoreFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	return patterns, scanner.Err()
+}
+
+// ignoreMatcher supports a subset of .gitignore syntax: patterns with a slash match the path from the root,
+// others match the name at any depth, a trailing slash matches directories only. Wildcards follow path.Match.
+type ignoreMatcher struct {
+	patterns []string
+}
+
+func (m ignoreMatcher) match(rel string, isDir bool) bool {
+	for _, pattern := range m.patterns {
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+
+		target := path.Base(rel)
+		if strings.Contains(pattern, "/") {
+			pattern = strings.TrimPrefix(pattern, "/")
+			target = rel
+		}
+
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+
+	return false
+}
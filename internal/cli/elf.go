@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const ntGNUBuildID = 3
+
+// DebugFileDirectories are searched for separate debug files the way gdb does
+var DebugFileDirectories = []string{"/usr/lib/debug"}
+
+// ELFDebug tells how an ELF binary refers to its debug twin
+type ELFDebug struct {
+	BuildID      string // Hex of .note.gnu.build-id, the same in the stripped and the debug binary
+	DebugLink    string // File name from .gnu_debuglink of a stripped binary
+	DebugLinkCRC uint32 // CRC-32 of the debug file
+	HasDebugInfo bool
+}
+
+func IsELF(path string) bool {
+	file, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
+
+// ReadELFDebug reads the build-id note and the debug link section, both are optional
+func ReadELFDebug(path string) (*ELFDebug, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	debug := &ELFDebug{HasDebugInfo: file.Section(".debug_info") != nil || file.Section(".zdebug_info") != nil}
+
+	if section := file.Section(".note.gnu.build-id"); section != nil {
+		data, err := section.Data()
+		if err != nil {
+			return nil, fmt.Errorf("invalid build-id note: %w", err)
+		}
+		debug.BuildID = parseBuildIDNote(data, file.ByteOrder)
+	}
+
+	if section := file.Section(".gnu_debuglink"); section != nil {
+		data, err := section.Data()
+		if err != nil {
+			return nil, fmt.Errorf("invalid debug link: %w", err)
+		}
+		// Null terminated file name, padded to 4 bytes, then the CRC
+		if i := bytes.IndexByte(data, 0); i > 0 {
+			debug.DebugLink = string(data[:i])
+			if offset := (i + 4) &^ 3; offset+4 <= len(data) {
+				debug.DebugLinkCRC = file.ByteOrder.Uint32(data[offset:])
+			}
+		}
+	}
+
+	return debug, nil
+}
+
+func parseBuildIDNote(data []byte, order binary.ByteOrder) string {
+	for len(data) >= 12 {
+		nameSize := int(order.Uint32(data[0:]))
+		descSize := int(order.Uint32(data[4:]))
+		noteType := order.Uint32(data[8:])
+		nameEnd := 12 + (nameSize+3)&^3
+		descEnd := nameEnd + (descSize+3)&^3
+		if nameEnd > len(data) || nameEnd+descSize > len(data) {
+			return ""
+		}
+
+		if noteType == ntGNUBuildID && string(bytes.TrimRight(data[12:12+nameSize], "\x00")) == "GNU" {
+			return hex.EncodeToString(data[nameEnd : nameEnd+descSize])
+		}
+
+		if descEnd > len(data) {
+			return ""
+		}
+		data = data[descEnd:]
+	}
+	return ""
+}
+
+// FindDebugFile looks for the debug twin of a stripped binary by build-id, then by debug link
+func FindDebugFile(path string) (string, error) {
+	debug, err := ReadELFDebug(path)
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	if len(debug.BuildID) > 2 {
+		for _, dir := range DebugFileDirectories {
+			candidates = append(candidates, filepath.Join(dir, ".build-id", debug.BuildID[:2], debug.BuildID[2:]+".debug"))
+		}
+	}
+	if debug.DebugLink != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		dir := filepath.Dir(abs)
+		candidates = append(candidates, filepath.Join(dir, debug.DebugLink), filepath.Join(dir, ".debug", debug.DebugLink))
+		for _, root := range DebugFileDirectories {
+			candidates = append(candidates, filepath.Join(root, dir, debug.DebugLink))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if candidate == path {
+			continue
+		}
+		if MatchDebugFile(path, candidate) == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", errors.New("debug file not found")
+}
+
+// MatchDebugFile checks that the debug file was split from the stripped binary, by build-id or by debug link CRC
+func MatchDebugFile(stripped string, debugPath string) error {
+	product, err := ReadELFDebug(stripped)
+	if err != nil {
+		return err
+	}
+	twin, err := ReadELFDebug(debugPath)
+	if err != nil {
+		return err
+	}
+
+	if product.BuildID != "" && twin.BuildID != "" {
+		if product.BuildID != twin.BuildID {
+			return fmt.Errorf("build-id %s does not match %s", twin.BuildID, product.BuildID)
+		}
+		return nil
+	}
+
+	if product.DebugLink != "" {
+		crc, err := crc32File(debugPath)
+		if err != nil {
+			return err
+		}
+		if crc != product.DebugLinkCRC {
+			return fmt.Errorf("debug link CRC %08x does not match %08x", crc, product.DebugLinkCRC)
+		}
+		return nil
+	}
+
+	return errors.New("no build-id or debug link to match")
+}
+
+func crc32File(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	hasher := crc32.NewIEEE()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return 0, err
+	}
+	return hasher.Sum32(), nil
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	OutputFormatTable   = "table"
+	OutputFormatJSON    = "json"
+	OutputFormatCSV     = "csv"
+	OutputFormatDefault = OutputFormatTable
+)
+
+var OutputFormats = []string{OutputFormatTable, OutputFormatJSON, OutputFormatCSV}
+
+func IsValidOutputFormat(format string) bool {
+	for _, f := range OutputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Render writes the rows in the format, JSON is rendered from the data itself so no field is lost
+func Render(w io.Writer, format string, columns []string, rows [][]string, data interface{}) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+	case OutputFormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(columns)
+		writer.WriteAll(rows)
+		return writer.Error()
+	case OutputFormatTable:
+		writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.Join(columns, "\t"))
+		for _, row := range rows {
+			// Tabs and line breaks of free text would break the columns
+			cells := make([]string, len(row))
+			for i, cell := range row {
+				cells[i] = strings.Join(strings.Fields(cell), " ")
+			}
+			fmt.Fprintln(writer, strings.Join(cells, "\t"))
+		}
+		return writer.Flush()
+	}
+	return fmt.Errorf("unknown output format '%s'", format)
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"github.com/b4bay/aspm/internal/shared"
+	"net/url"
+	"strings"
+)
+
+// Levels are the finding levels from the least to the most severe, as SARIF has them
+var Levels = []string{"none", "note", "warning", "error"}
+
+func IsValidLevel(level string) bool {
+	return levelRank(level) >= 0
+}
+
+func levelRank(level string) int {
+	for i, l := range Levels {
+		if l == strings.ToLower(level) {
+			return i
+		}
+	}
+	return -1
+}
+
+// GateReport is the comparison of the findings of a product with its baseline, as the server makes it
+type GateReport struct {
+	ProductID  string       `json:"product_id"`
+	BaselineID string       `json:"baseline_id,omitempty"`
+	Introduced []Finding    `json:"introduced"`
+	Fixed      []Finding    `json:"fixed"`
+	Unchanged  []Finding    `json:"unchanged"`
+	Evidence   []Engagement `json:"evidence"` // Engagements the findings come from
+}
+
+// CompareFindings compares the findings of the product with the baseline, a product id or the ref of the target branch.
+// Without a baseline every finding of the product is introduced.
+func CompareFindings(client ASPMClientInterface, productID string, baseline string) (GateReport, error) {
+	query := url.Values{}
+	query.Set("product", productID)
+	if baseline != "" {
+		query.Set("baseline", baseline)
+	}
+
+	var report GateReport
+	return report, client.Get("/gw", query, &report)
+}
+
+// Unsigned returns the evidence not signed by a registered key
+func (r GateReport) Unsigned() []Engagement {
+	var unsigned []Engagement
+	for _, engagement := range r.Evidence {
+		if engagement.Signer == "" {
+			unsigned = append(unsigned, engagement)
+		}
+	}
+	return unsigned
+}
+
+// Breaking returns the introduced findings at the level or above, findings decided not to matter are left out
+func (r GateReport) Breaking(level string) []Finding {
+	var breaking []Finding
+	for _, finding := range r.Introduced {
+		if finding.Status == string(shared.StatusFalsePositive) || finding.Status == string(shared.StatusNoImpact) {
+			continue
+		}
+		if level != "" && levelRank(finding.Level) < levelRank(level) {
+			continue
+		}
+		breaking = append(breaking, finding)
+	}
+	return breaking
+}
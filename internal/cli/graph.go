@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	GraphFormatTree    = "tree"
+	GraphFormatDot     = "dot"
+	GraphFormatJSON    = "json"
+	GraphFormatDefault = GraphFormatTree
+)
+
+var GraphFormats = []string{GraphFormatTree, GraphFormatDot, GraphFormatJSON}
+
+func IsValidGraphFormat(format string) bool {
+	for _, f := range GraphFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// LineageNode is a product in the lineage as the server walks it, the method is the one of the link to its parent node
+type LineageNode struct {
+	ProductID    string        `json:"product_id"`
+	Name         string        `json:"name"`
+	Type         string        `json:"type"`
+	Method       string        `json:"method,omitempty"`
+	OpenFindings int64         `json:"open_findings"`
+	Origins      []LineageNode `json:"origins,omitempty"`
+	Products     []LineageNode `json:"products,omitempty"`
+	Truncated    bool          `json:"truncated,omitempty"`
+	Repeated     bool          `json:"repeated,omitempty"`
+}
+
+// Lineage fetches the lineage of the product, direction is up, down or both, zero depth is the server default
+func Lineage(client ASPMClientInterface, id string, direction string, depth int) (LineageNode, error) {
+	query := url.Values{}
+	query.Set("id", id)
+	query.Set("direction", direction)
+	if depth > 0 {
+		query.Set("depth", strconv.Itoa(depth))
+	}
+
+	var node LineageNode
+	return node, client.Get("/ui/lineage", query, &node)
+}
+
+func (n LineageNode) label() string {
+	label := n.ProductID
+	if n.Name != "" {
+		label = n.Name + " " + label
+	}
+	if n.Type != "" {
+		label += " (" + n.Type + ")"
+	}
+	return fmt.Sprintf("%s, %d open findings", label, n.OpenFindings)
+}
+
+// RenderGraph writes the lineage in the format
+func RenderGraph(w io.Writer, format string, root LineageNode) error {
+	switch format {
+	case GraphFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(root)
+	case GraphFormatDot:
+		return renderDot(w, root)
+	case GraphFormatTree:
+		fmt.Fprintln(w, root.label())
+		if len(root.Origins) > 0 {
+			fmt.Fprintln(w, "↑ origins")
+			renderTree(w, root.Origins, "", func(n LineageNode) []LineageNode { return n.Origins })
+		}
+		if len(root.Products) > 0 {
+			fmt.Fprintln(w, "↓ products")
+			renderTree(w, root.Products, "", func(n LineageNode) []LineageNode { return n.Products })
+		}
+		if root.Truncated {
+			fmt.Fprintln(w, "… deeper lineage not shown")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown graph format '%s'", format)
+}
+
+func renderTree(w io.Writer, nodes []LineageNode, indent string, children func(LineageNode) []LineageNode) {
+	for i, node := range nodes {
+		branch, next := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		line := indent + branch + node.Method + ": " + node.label()
+		if node.Truncated {
+			line += " …"
+		}
+		if node.Repeated {
+			line += " (shown elsewhere)"
+		}
+		fmt.Fprintln(w, line)
+		renderTree(w, children(node), indent+next, children)
+	}
+}
+
+// renderDot draws edges from origins to the products made of them, whichever direction they were walked in
+func renderDot(w io.Writer, root LineageNode) error {
+	fmt.Fprintln(w, "digraph lineage {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+
+	seen := map[string]bool{}
+	var node func(n LineageNode)
+	node = func(n LineageNode) {
+		if seen[n.ProductID] {
+			return
+		}
+		seen[n.ProductID] = true
+		fmt.Fprintf(w, "  %s [label=%s];\n", strconv.Quote(n.ProductID), strconv.Quote(strings.Replace(n.label(), ", ", "\n", 1)))
+	}
+
+	// A link is drawn once, however many times the walk reached it
+	edges := map[string]bool{}
+	edge := func(from string, to string, method string) {
+		line := fmt.Sprintf("  %s -> %s [label=%s];", strconv.Quote(from), strconv.Quote(to), strconv.Quote(method))
+		if !edges[line] {
+			edges[line] = true
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	var walk func(n LineageNode)
+	walk = func(n LineageNode) {
+		node(n)
+		for _, origin := range n.Origins {
+			walk(origin)
+			edge(origin.ProductID, n.ProductID, origin.Method)
+		}
+		for _, product := range n.Products {
+			walk(product)
+			edge(n.ProductID, product.ProductID, product.Method)
+		}
+	}
+	walk(root)
+
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
@@ -2,9 +2,12 @@ package cli
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"os/exec"
@@ -14,6 +17,12 @@ import (
 
 var Exit = os.Exit
 
+// IsGit tells whether the directory is the root of a git work tree, other directories are dir artefacts
+func IsGit(path string) bool {
+	_, err := os.Stat(filepath.Join(path, ".git"))
+	return err == nil
+}
+
 func IdGit(path string) (string, error) {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -34,7 +43,7 @@ func IdGit(path string) (string, error) {
 	cmd.Dir = path
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%s: %w", strings.TrimSpace(string(output)), err)
 	}
 
 	return strings.TrimSpace(string(output)), nil
@@ -57,8 +66,13 @@ func IdBin(path string) (string, error) {
 	}
 	defer file.Close()
 
+	return hashReader(file)
+}
+
+// hashReader computes the binary id of the content
+func hashReader(r io.Reader) (string, error) {
 	hasher := sha1.New()
-	if _, err := io.Copy(hasher, file); err != nil {
+	if _, err := io.Copy(hasher, r); err != nil {
 		return "", err
 	}
 
@@ -66,6 +80,51 @@ func IdBin(path string) (string, error) {
 	return strings.TrimSpace(hash), nil
 }
 
+// DigestsBin computes every digest of shared.DigestAlgorithms in one pass, the sha1 one is the IdBin id
+func DigestsBin(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, errors.New("not a file")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return digestReader(file)
+}
+
+func digestReader(r io.Reader) (map[string]string, error) {
+	writer, digests := digestWriter()
+	if _, err := io.Copy(writer, r); err != nil {
+		return nil, err
+	}
+	return digests(), nil
+}
+
+// digestWriter hashes what is written to it, digests are read once everything is written
+func digestWriter() (io.Writer, func() map[string]string) {
+	hashers := map[string]hash.Hash{"sha1": sha1.New(), "sha256": sha256.New(), "sha512": sha512.New()}
+	var writers []io.Writer
+	for _, h := range hashers {
+		writers = append(writers, h)
+	}
+
+	return io.MultiWriter(writers...), func() map[string]string {
+		digests := map[string]string{}
+		for algorithm, h := range hashers {
+			digests[algorithm] = hex.EncodeToString(h.Sum(nil))
+		}
+		return digests
+	}
+}
+
 func NameGit(path string) (string, error) {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -82,20 +141,12 @@ func NameGit(path string) (string, error) {
 		return "", errors.New("not a git repository")
 	}
 
-	// Gitlab cannot work with `git symbolic-ref HEAD` without additional checkout
+	// CI systems check out a detached HEAD, so `git symbolic-ref HEAD` works only outside of CI
 	// https://stackoverflow.com/questions/69267025/detached-head-in-gitlab-ci-pipeline-how-to-push-correctly/69268083#69268083
-	// Replaced with CI_COMMIT_REF_NAME approach
-	/*
-		cmd := exec.Command("git", "symbolic-ref", "HEAD")
-		cmd.Dir = path
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return "", err
-		}
-	*/
-	output := os.Getenv("CI_COMMIT_REF_NAME")
+	// The ref name comes from the CI provider, see GetCIContext
+	output := GetCIContext(path).Ref
 
-	return strings.TrimSpace(string(output)), nil
+	return strings.TrimSpace(output), nil
 }
 
 func NameBin(path string) (string, error) {
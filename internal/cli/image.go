@@ -0,0 +1,325 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/b4bay/aspm/internal/shared"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociLayoutFile     = "oci-layout"
+	ociIndexFile      = "index.json"
+	dockerManifest    = "manifest.json"
+	maxImageJSONSize  = 4 << 20 // Manifests and configs are small, layers are streamed
+	mediaTypeOCIIndex = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerML = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+	AnnotationBaseName   = "org.opencontainers.image.base.name"
+	annotationRefName    = "org.opencontainers.image.ref.name"
+	annotationImageName  = "io.containerd.image.name"
+)
+
+// Image is a container image read from an OCI image layout or a `docker save` tarball
+type Image struct {
+	Digest       string // Manifest digest, e.g. sha256:...
+	ConfigDigest string // Image id
+	Name         string
+	BaseDigest   string // Base image, if the image is annotated with it
+	BaseName     string
+	Binaries     []ArchiveMember // Executables found in the layers, when asked for
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	MediaType   string            `json:"mediaType"`
+	Config      ociDescriptor     `json:"config"`
+	Manifests   []ociDescriptor   `json:"manifests"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type dockerManifestEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+var ErrNotImage = errors.New("not a container image")
+
+// ProductMessage identifies the image by its manifest digest, the image id is an alias as scanners report it too
+func (i *Image) ProductMessage() shared.ProductMessage {
+	message := shared.ProductMessage{Id: i.Digest, Name: i.Name, Type: shared.ArtefactTypeImage}
+	if algorithm, digest, ok := strings.Cut(i.ConfigDigest, ":"); ok && i.ConfigDigest != i.Digest {
+		message.Digests = map[string]string{algorithm: digest}
+	}
+	return message
+}
+
+// ReadImage reads the image without a container daemon, in one pass over the layout or the tarball.
+// Layers are read for the executables they contain only when asked for, they make most of the image.
+// Legacy `docker save` tarballs have no manifest, the image id is the digest then.
+func ReadImage(path string, binaries bool) (*Image, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := imageReader{files: map[string][]byte{}, binaries: binaries, found: map[string]bool{}}
+	if info.IsDir() {
+		if _, err := os.Stat(filepath.Join(path, ociLayoutFile)); err != nil {
+			return nil, ErrNotImage
+		}
+		err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			name, _ := filepath.Rel(path, p)
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			file, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return reader.add(filepath.ToSlash(name), fi.Size(), file)
+		})
+	} else {
+		err = walkTar(path, reader.walk)
+	}
+
+	return reader.image(path, err)
+}
+
+// ReadImageOrDigests reads the file once for both the image it is and its digests, a file which is not
+// an image comes with its digests and ErrNotImage. Binaries are not read from the layers.
+func ReadImageOrDigests(path string) (*Image, map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	// The tar walk stops at the end of the archive or at the first invalid header, the rest is only hashed
+	digester, digests := digestWriter()
+	tee := io.TeeReader(file, digester)
+	reader := imageReader{files: map[string][]byte{}, found: map[string]bool{}}
+	image, err := reader.image(path, walkTarReader(tee, reader.walk))
+	if !errors.Is(err, ErrNotImage) {
+		return image, nil, err
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return nil, nil, err
+	}
+
+	return nil, digests(), ErrNotImage
+}
+
+// imageReader keeps the metadata files of the image and hashes the executables of its layers
+type imageReader struct {
+	files    map[string][]byte
+	binaries bool
+	members  []ArchiveMember
+	found    map[string]bool
+}
+
+func (ir *imageReader) walk(name string, size int64, r io.Reader) (bool, error) {
+	return true, ir.add(name, size, r)
+}
+
+// image makes the image of the files read, err is the one the walk over them ended with
+func (ir *imageReader) image(path string, err error) (*Image, error) {
+	_, layout := ir.files[ociLayoutFile]
+	_, manifest := ir.files[dockerManifest]
+	if err != nil && !layout && !manifest {
+		return nil, ErrNotImage
+	} else if err != nil {
+		return nil, err
+	}
+
+	var image *Image
+	if _, ok := ir.files[ociIndexFile]; ok {
+		if _, ok := ir.files[ociLayoutFile]; !ok {
+			return nil, ErrNotImage
+		}
+		image, err = imageFromOCILayout(ir.files)
+	} else if _, ok := ir.files[dockerManifest]; ok {
+		image, err = imageFromDockerManifest(ir.files)
+	} else {
+		return nil, ErrNotImage
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if image.Name == "" {
+		image.Name = filepath.Base(path)
+	}
+	image.Binaries = ir.members
+
+	return image, nil
+}
+
+func (ir *imageReader) add(name string, size int64, r io.Reader) error {
+	if !isImageMetadata(name) {
+		return nil
+	}
+	br := bufio.NewReader(r)
+
+	// Blobs are manifests, configs and layers, layers are the ones not JSON
+	if strings.HasPrefix(name, "blobs/") || strings.HasSuffix(name, "/layer.tar") {
+		if first, _ := br.Peek(1); len(first) == 1 && first[0] != '{' {
+			if !ir.binaries {
+				return nil
+			}
+			if err := walkTarReader(br, ir.addBinary); err != nil {
+				return fmt.Errorf("invalid layer '%s': %w", name, err)
+			}
+			return nil
+		}
+	}
+
+	if size > maxImageJSONSize {
+		return nil
+	}
+	content, err := io.ReadAll(br)
+	ir.files[name] = content
+	return err
+}
+
+// addBinary hashes the file of a layer if it is an executable, the same file in several layers is listed once
+func (ir *imageReader) addBinary(name string, size int64, r io.Reader) (bool, error) {
+	br := bufio.NewReader(r)
+	if size == 0 || strings.HasPrefix(path.Base(name), ".wh.") || !isExecutable(br) {
+		return true, nil
+	}
+	digests, err := digestReader(br)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash '%s': %w", name, err)
+	}
+	if !ir.found[digests["sha1"]] {
+		ir.found[digests["sha1"]] = true
+		ir.members = append(ir.members, ArchiveMember{Path: name, Id: digests["sha1"], Digests: digests})
+	}
+	return true, nil
+}
+
+// isExecutable tells ELF, PE and Mach-O files by their magic bytes
+func isExecutable(r *bufio.Reader) bool {
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x7f, 'E', 'L', 'F'}), bytes.HasPrefix(magic, []byte("MZ")):
+		return true
+	case bytes.Equal(magic, []byte{0xfe, 0xed, 0xfa, 0xce}), bytes.Equal(magic, []byte{0xfe, 0xed, 0xfa, 0xcf}),
+		bytes.Equal(magic, []byte{0xce, 0xfa, 0xed, 0xfe}), bytes.Equal(magic, []byte{0xcf, 0xfa, 0xed, 0xfe}):
+		return true
+	}
+	return false
+}
+
+func imageFromOCILayout(files map[string][]byte) (*Image, error) {
+	var index ociIndex
+	if err := json.Unmarshal(files[ociIndexFile], &index); err != nil {
+		return nil, fmt.Errorf("invalid image index: %w", err)
+	}
+	if len(index.Manifests) == 0 || !strings.Contains(index.Manifests[0].Digest, ":") {
+		return nil, errors.New("image index has no manifests")
+	}
+
+	descriptor := index.Manifests[0]
+	image := &Image{
+		Digest: descriptor.Digest,
+		Name:   firstNonEmpty(descriptor.Annotations[annotationImageName], descriptor.Annotations[annotationRefName]),
+	}
+
+	// Base image annotations are set on the manifest, or on the first manifest of a multi-platform index
+	annotations := descriptor.Annotations
+	if blob, ok := files[blobPath(descriptor.Digest)]; ok {
+		var manifest ociIndex
+		if err := json.Unmarshal(blob, &manifest); err == nil {
+			annotations = mergeAnnotations(annotations, manifest.Annotations)
+			image.ConfigDigest = manifest.Config.Digest
+			if (descriptor.MediaType == mediaTypeOCIIndex || descriptor.MediaType == mediaTypeDockerML) && len(manifest.Manifests) > 0 {
+				if nested, ok := files[blobPath(manifest.Manifests[0].Digest)]; ok {
+					var platformManifest ociIndex
+					if err := json.Unmarshal(nested, &platformManifest); err == nil {
+						annotations = mergeAnnotations(annotations, platformManifest.Annotations)
+					}
+				}
+			}
+		}
+	}
+
+	image.BaseDigest = annotations[AnnotationBaseDigest]
+	image.BaseName = annotations[AnnotationBaseName]
+
+	return image, nil
+}
+
+func imageFromDockerManifest(files map[string][]byte) (*Image, error) {
+	// Release tarballs have a manifest.json of their own, only the shape of a docker one makes an image
+	var manifest []dockerManifestEntry
+	if err := json.Unmarshal(files[dockerManifest], &manifest); err != nil || len(manifest) == 0 || manifest[0].Config == "" {
+		return nil, ErrNotImage
+	}
+
+	// Config is either "<hex>.json" or "blobs/sha256/<hex>"
+	config := manifest[0].Config
+	if _, ok := files[config]; !ok {
+		return nil, ErrNotImage
+	}
+	image := &Image{
+		Digest: "sha256:" + strings.TrimSuffix(path.Base(config), ".json"),
+	}
+	image.ConfigDigest = image.Digest
+	if len(manifest[0].RepoTags) > 0 {
+		image.Name = manifest[0].RepoTags[0]
+	}
+
+	return image, nil
+}
+
+func isImageMetadata(name string) bool {
+	return name == ociLayoutFile || name == ociIndexFile || name == dockerManifest ||
+		strings.HasPrefix(name, "blobs/") || strings.HasSuffix(name, "/layer.tar") || !strings.Contains(name, "/") && strings.HasSuffix(name, ".json")
+}
+
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func mergeAnnotations(a map[string]string, b map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		if merged[k] == "" {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,444 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/b4bay/aspm/internal/shared"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Component is a third party library version a lock file of the repository pins
+type Component struct {
+	Purl    string
+	Name    string
+	Version string
+	File    string // Lock file the component is found in, relative to the repository
+}
+
+type lockFileParser func(content []byte) ([]Component, error)
+
+// LockFiles are recognized by name, go.sum is read only when there is no go.mod next to it
+var LockFiles = map[string]lockFileParser{
+	"go.mod":            parseGoMod,
+	"go.sum":            parseGoSum,
+	"package-lock.json": parsePackageLock,
+	"yarn.lock":         parseYarnLock,
+	"poetry.lock":       parsePoetryLock,
+	"requirements.txt":  parseRequirements,
+	"Cargo.lock":        parseCargoLock,
+	"pom.xml":           parsePom,
+}
+
+// Directories with installed or vendored copies of dependencies, their own lock files are not the repository's
+var skippedDirs = map[string]bool{".git": true, "node_modules": true, "vendor": true, "target": true, ".venv": true}
+
+// ReadComponents finds lock files in the repository and returns the components they pin, sorted by purl.
+// A component pinned by several lock files is returned once.
+func ReadComponents(dir string, ignore []string) ([]Component, error) {
+	patterns, err := readIgnoreFile(filepath.Join(dir, IgnoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	matcher := ignoreMatcher{patterns: append(append([]string{}, ignore...), patterns...)}
+
+	found := map[string]Component{}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		if matcher.match(rel, d.IsDir()) || (d.IsDir() && skippedDirs[d.Name()]) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		parse, ok := LockFiles[d.Name()]
+		if !ok || !d.Type().IsRegular() {
+			return nil
+		}
+		if d.Name() == "go.sum" {
+			if _, err := os.Stat(filepath.Join(filepath.Dir(p), "go.mod")); err == nil {
+				return nil
+			}
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		components, err := parse(content)
+		if err != nil {
+			return fmt.Errorf("invalid lock file '%s': %w", rel, err)
+		}
+		for _, c := range components {
+			if _, ok := found[c.Purl]; !ok {
+				c.File = rel
+				found[c.Purl] = c
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var components []Component
+	for _, c := range found {
+		components = append(components, c)
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i].Purl < components[j].Purl })
+
+	return components, nil
+}
+
+// newPurl builds a normalized package URL, the name may have a namespace, e.g. "@types/node" or "github.com/pkg/errors"
+func newPurl(typ string, name string, version string) string {
+	purl := shared.PackageURL{Type: typ, Name: name, Version: version}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		purl.Namespace, purl.Name = name[:i], name[i+1:]
+	}
+
+	// Round trip to apply the type specific rules, e.g. lowercase npm names
+	if normalized, err := shared.ParsePurl(purl.String()); err == nil {
+		return normalized.String()
+	}
+	return purl.String()
+}
+
+func newComponent(typ string, name string, version string) Component {
+	return Component{Purl: newPurl(typ, name, version), Name: name, Version: version}
+}
+
+func parseGoMod(content []byte) ([]Component, error) {
+	versions := map[string]string{}
+	replaced := map[string]GoModule{}
+	var block string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+
+		if line == ")" {
+			block = ""
+			continue
+		}
+		fields := strings.Fields(line)
+		directive := block
+		if block == "" {
+			directive = fields[0]
+			fields = fields[1:]
+			if len(fields) == 1 && fields[0] == "(" {
+				block = directive
+				continue
+			}
+		}
+
+		switch directive {
+		case "require":
+			if len(fields) >= 2 {
+				versions[fields[0]] = fields[1]
+			}
+		case "replace":
+			// old [version] => new [version], replacements by a local path have no version
+			if i := indexOf(fields, "=>"); i > 0 && len(fields) == i+3 {
+				replaced[fields[0]] = GoModule{Path: fields[i+1], Version: fields[i+2]}
+			} else if i > 0 {
+				replaced[fields[0]] = GoModule{}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var components []Component
+	for modulePath, version := range versions {
+		module := GoModule{Path: modulePath, Version: version}
+		if replacement, ok := replaced[modulePath]; ok {
+			module = replacement
+		}
+		if module.Version != "" {
+			components = append(components, newComponent("golang", module.Path, module.Version))
+		}
+	}
+
+	return components, nil
+}
+
+func parseGoSum(content []byte) ([]Component, error) {
+	var components []Component
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Lines of go.mod hashes are for modules whose code may be never downloaded
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		components = append(components, newComponent("golang", fields[0], fields[1]))
+	}
+
+	return components, scanner.Err()
+}
+
+// npmPackage is an entry of "packages", its dependencies are version ranges and are not needed
+type npmPackage struct {
+	Version string `json:"version"`
+	Link    bool   `json:"link"`
+}
+
+// npmDependency is an entry of "dependencies" of lockfileVersion 1, nested ones are installed under it
+type npmDependency struct {
+	Version      string                   `json:"version"`
+	Dependencies map[string]npmDependency `json:"dependencies"`
+}
+
+func parsePackageLock(content []byte) ([]Component, error) {
+	var lock struct {
+		Packages     map[string]npmPackage    `json:"packages"`     // lockfileVersion 2 and 3
+		Dependencies map[string]npmDependency `json:"dependencies"` // lockfileVersion 1
+	}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, err
+	}
+
+	var components []Component
+	// Links, git and tarball dependencies have no registry version
+	add := func(name string, version string) {
+		if isSemver(version) {
+			components = append(components, newComponent("npm", name, version))
+		}
+	}
+
+	if len(lock.Packages) > 0 {
+		for key, p := range lock.Packages {
+			if i := strings.LastIndex(key, "node_modules/"); i >= 0 && !p.Link {
+				add(key[i+len("node_modules/"):], p.Version)
+			}
+		}
+		return components, nil
+	}
+
+	var walk func(dependencies map[string]npmDependency)
+	walk = func(dependencies map[string]npmDependency) {
+		for name, dependency := range dependencies {
+			add(name, dependency.Version)
+			walk(dependency.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+
+	return components, nil
+}
+
+// parseYarnLock reads both the classic format and the YAML one of yarn 2+
+func parseYarnLock(content []byte) ([]Component, error) {
+	var components []Component
+	var name string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			// Entry header, e.g. `"@babel/core@^7.0.0", "@babel/core@^7.1.0":` or `lodash@npm:^4.17.21:`
+			spec := strings.Trim(strings.TrimSpace(strings.Split(strings.TrimSuffix(line, ":"), ",")[0]), `"`)
+			name = ""
+			if i := strings.LastIndex(spec[min(1, len(spec)):], "@"); i >= 0 {
+				descriptor := spec[i+2:]
+				if !strings.Contains(descriptor, ":") || strings.HasPrefix(descriptor, "npm:") {
+					name = spec[:i+1]
+				}
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if name != "" && (strings.HasPrefix(trimmed, "version ") || strings.HasPrefix(trimmed, "version:")) {
+			version := strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(trimmed, "version"), ":")), `"`)
+			if isSemver(version) {
+				components = append(components, newComponent("npm", name, version))
+			}
+			name = ""
+		}
+	}
+
+	return components, scanner.Err()
+}
+
+func parsePoetryLock(content []byte) ([]Component, error) {
+	var components []Component
+	for _, p := range tomlPackages(content) {
+		if p["name"] != "" && p["version"] != "" {
+			components = append(components, newComponent("pypi", p["name"], p["version"]))
+		}
+	}
+	return components, nil
+}
+
+func parseCargoLock(content []byte) ([]Component, error) {
+	var components []Component
+	for _, p := range tomlPackages(content) {
+		// Crates without a source are the members of the workspace itself
+		if p["name"] != "" && p["version"] != "" && p["source"] != "" {
+			components = append(components, newComponent("cargo", p["name"], p["version"]))
+		}
+	}
+	return components, nil
+}
+
+// tomlPackages reads the string keys of [[package]] tables, which is all poetry.lock and Cargo.lock need
+func tomlPackages(content []byte) []map[string]string {
+	var packages []map[string]string
+	var current map[string]string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			current = nil
+			if line == "[[package]]" {
+				current = map[string]string{}
+				packages = append(packages, current)
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		value = strings.TrimSpace(value)
+		if ok && strings.HasPrefix(value, `"`) {
+			current[strings.TrimSpace(key)] = strings.Trim(value, `"`)
+		}
+	}
+
+	return packages
+}
+
+var requirementPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)\s*(\[[^\]]*\])?\s*===?\s*([^\s;#,]+)`)
+
+// parseRequirements takes pinned requirements only, ranges do not tell which version is used
+func parseRequirements(content []byte) ([]Component, error) {
+	var components []Component
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if m := requirementPattern.FindStringSubmatch(strings.TrimSpace(scanner.Text())); m != nil {
+			components = append(components, newComponent("pypi", m[1], m[3]))
+		}
+	}
+	return components, scanner.Err()
+}
+
+type pomDependency struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Version    string `xml:"version"`
+	Scope      string `xml:"scope"`
+}
+
+type pomProperty struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// parsePom reads the dependencies declared by the pom, properties defined in it are resolved.
+// Parent poms and imported BOMs are not fetched, dependencies versioned by them only are skipped.
+func parsePom(content []byte) ([]Component, error) {
+	var pom struct {
+		GroupID string `xml:"groupId"`
+		Version string `xml:"version"`
+		Parent  struct {
+			GroupID string `xml:"groupId"`
+			Version string `xml:"version"`
+		} `xml:"parent"`
+		Properties struct {
+			Entries []pomProperty `xml:",any"`
+		} `xml:"properties"`
+		Managed      []pomDependency `xml:"dependencyManagement>dependencies>dependency"`
+		Dependencies []pomDependency `xml:"dependencies>dependency"`
+	}
+	if err := xml.Unmarshal(content, &pom); err != nil {
+		return nil, err
+	}
+
+	properties := map[string]string{
+		"project.groupId":        firstNonEmpty(pom.GroupID, pom.Parent.GroupID),
+		"project.version":        firstNonEmpty(pom.Version, pom.Parent.Version),
+		"project.parent.version": pom.Parent.Version,
+	}
+	for _, p := range pom.Properties.Entries {
+		properties[p.XMLName.Local] = strings.TrimSpace(p.Value)
+	}
+	resolve := func(value string) string {
+		return os.Expand(strings.TrimSpace(value), func(key string) string {
+			if v, ok := properties[key]; ok {
+				return v
+			}
+			return "${" + key + "}"
+		})
+	}
+
+	managed := map[string]string{}
+	for _, d := range pom.Managed {
+		managed[resolve(d.GroupID)+":"+resolve(d.ArtifactID)] = resolve(d.Version)
+	}
+
+	var components []Component
+	for _, d := range pom.Dependencies {
+		// Test dependencies never ship with the product
+		if d.Scope == "test" {
+			continue
+		}
+		group, artifact := resolve(d.GroupID), resolve(d.ArtifactID)
+		version := resolve(d.Version)
+		if version == "" {
+			version = managed[group+":"+artifact]
+		}
+		if group == "" || artifact == "" || version == "" || strings.Contains(version, "${") || strings.ContainsAny(version, "[(") {
+			continue
+		}
+		components = append(components, newComponent("maven", path.Join(group, artifact), version))
+	}
+
+	return components, nil
+}
+
+func isSemver(version string) bool {
+	return version != "" && version[0] >= '0' && version[0] <= '9'
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ExpandOrigins turns origin arguments into paths. Globs are expanded, with "**" matching any number of directories
+// and files only, and "@file" reads further arguments from the file, one per line. Paths matching an exclude pattern
+// are left out, patterns follow the subset of .gitignore syntax of dir artefacts.
+func ExpandOrigins(args []string, exclude []string) ([]string, error) {
+	matcher := ignoreMatcher{patterns: exclude}
+	seen := map[string]bool{}
+	paths := []string{}
+
+	var expand func(args []string, depth int) error
+	expand = func(args []string, depth int) error {
+		for _, arg := range args {
+			if listPath, ok := strings.CutPrefix(arg, "@"); ok {
+				// A list naming itself would never end
+				if depth > 8 {
+					return fmt.Errorf("origin list '%s' nested too deep", listPath)
+				}
+				if _, err := os.Stat(listPath); err != nil {
+					return fmt.Errorf("origin list '%s' is not readable: %w", listPath, err)
+				}
+				lines, err := readIgnoreFile(listPath)
+				if err != nil {
+					return fmt.Errorf("origin list '%s' is not readable: %w", listPath, err)
+				}
+				if err := expand(lines, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+
+			matches := []string{arg}
+			if strings.ContainsAny(arg, "*?[") {
+				var err error
+				if matches, err = glob(arg); err != nil {
+					return fmt.Errorf("invalid origin pattern '%s': %w", arg, err)
+				}
+				if len(matches) == 0 {
+					return fmt.Errorf("origin pattern '%s' matches no files", arg)
+				}
+			}
+
+			for _, match := range matches {
+				rel := filepath.ToSlash(filepath.Clean(match))
+				info, err := os.Stat(match)
+				if seen[rel] || matcher.match(rel, err == nil && info.IsDir()) {
+					continue
+				}
+				seen[rel] = true
+				paths = append(paths, match)
+			}
+		}
+		return nil
+	}
+
+	return paths, expand(args, 0)
+}
+
+// glob is filepath.Glob with "**" support
+func glob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+
+	// Walk from the longest directory without wildcards
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	var root []string
+	for _, segment := range segments {
+		if strings.ContainsAny(segment, "*?[") {
+			break
+		}
+		root = append(root, segment)
+	}
+	rootPath := strings.Join(root, "/")
+	if rootPath == "" {
+		rootPath = "."
+		if strings.HasPrefix(pattern, "/") {
+			rootPath = "/"
+		}
+	}
+
+	var matches []string
+	err := filepath.WalkDir(filepath.FromSlash(rootPath), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel := filepath.ToSlash(p)
+		if rootPath == "." {
+			rel = strings.TrimPrefix(rel, "./")
+		}
+		if matched, err := matchSegments(segments, strings.Split(rel, "/")); err != nil {
+			return err
+		} else if matched {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return matches, err
+}
+
+func matchSegments(pattern []string, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matched, err := matchSegments(pattern[1:], name[i:]); err != nil || matched {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		if matched, err := path.Match(pattern[0], name[0]); err != nil || !matched {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// ForEach calls fn for every index up to count on at most workers goroutines at a time,
+// done is called with the number of calls completed so far after each one, never concurrently
+func ForEach(count int, workers int, fn func(i int), done func(completed int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	completed := 0
+	for w := 0; w < workers && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+				mu.Lock()
+				completed++
+				if done != nil {
+					done(completed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
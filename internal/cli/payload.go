@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/b4bay/aspm/internal/shared"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AttestationExtension marks in-toto JSON lines files, one attestation per line
+const AttestationExtension = ".jsonl"
+
+// Upload is a payload together with the endpoint it is meant for
+type Upload struct {
+	Endpoint string          `json:"endpoint"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// NewUpload marshals the payload for the endpoint
+func NewUpload(endpoint string, data interface{}) (Upload, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Upload{}, fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return Upload{Endpoint: endpoint, Payload: payload}, nil
+}
+
+// WritePayloadFile saves the uploads of a run into the file, in the order they were made,
+// so they can be sent later from another host
+func WritePayloadFile(path string, uploads []Upload) error {
+	content, err := json.MarshalIndent(uploads, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal uploads: %w", err)
+	}
+	return writeFile(path, append(content, '\n'))
+}
+
+// WriteAttestations saves the envelopes as in-toto JSON lines
+func WriteAttestations(path string, envelopes []shared.Envelope) error {
+	var content []byte
+	for _, envelope := range envelopes {
+		line, err := json.Marshal(envelope)
+		if err != nil {
+			return fmt.Errorf("failed to marshal attestation: %w", err)
+		}
+		content = append(append(content, line...), '\n')
+	}
+	return writeFile(path, content)
+}
+
+// ReadAttestations loads the attestations of an in-toto JSON lines file as uploads to the attestation endpoint
+func ReadAttestations(path string) ([]Upload, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var uploads []Upload
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !json.Valid([]byte(line)) {
+			return nil, fmt.Errorf("invalid attestation file: line %d is not JSON", len(uploads)+1)
+		}
+		uploads = append(uploads, Upload{Endpoint: "/attestation", Payload: json.RawMessage(line)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(uploads) == 0 {
+		return nil, fmt.Errorf("invalid attestation file: no attestations")
+	}
+	return uploads, nil
+}
+
+// writeFile writes the whole content or nothing: same as the spool,
+// a run interrupted while writing never leaves a truncated file behind
+func writeFile(path string, content []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create payload file: %w", err)
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to write payload file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to write payload file: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to finalize payload file: %w", err)
+	}
+	return nil
+}
+
+// ReadPayloadFile loads the uploads saved by WritePayloadFile, a single spool entry is read as one upload
+func ReadPayloadFile(path string) ([]Upload, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []Upload
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		var upload Upload
+		err = json.Unmarshal(content, &upload)
+		uploads = []Upload{upload}
+	} else {
+		err = json.Unmarshal(content, &uploads)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload file: %w", err)
+	}
+
+	if len(uploads) == 0 {
+		return nil, fmt.Errorf("invalid payload file: no uploads")
+	}
+	for _, upload := range uploads {
+		if upload.Endpoint == "" || len(upload.Payload) == 0 {
+			return nil, fmt.Errorf("invalid payload file: endpoint or payload is missing")
+		}
+	}
+	return uploads, nil
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Product is a product as the server lists it
+type Product struct {
+	ID        uint      `json:"id"`
+	ProductID string    `json:"product_id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Project   string    `json:"project"`
+	Ref       string    `json:"ref,omitempty"`
+	Author    string    `json:"author"`
+	Worker    string    `json:"worker"`
+	Dirty     bool      `json:"dirty,omitempty"`
+	BuildID   string    `json:"build_id,omitempty"`
+	Purl      string    `json:"purl,omitempty"`
+	CPE       string    `json:"cpe,omitempty"`
+	Aliases   []string  `json:"aliases,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Finding is a vulnerability as the server lists it
+type Finding struct {
+	ID              uint      `json:"id"`
+	VulnerabilityID string    `json:"vuln_id"`
+	LocationHash    string    `json:"location_hash"`
+	ProductID       string    `json:"product_id"`
+	Level           string    `json:"level"`
+	Text            string    `json:"text"`
+	CWE             string    `json:"cwe"`
+	CVE             string    `json:"cve"`
+	FileHash        string    `json:"file_hash,omitempty"`
+	Fingerprint     string    `json:"fingerprint,omitempty"`
+	Status          string    `json:"status,omitempty"`
+	StatusReason    string    `json:"status_reason,omitempty"`
+	EngagementID    uint      `json:"engagement_id"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Engagement is an uploaded report as the server lists it
+type Engagement struct {
+	ID           uint      `json:"id"`
+	ProductID    string    `json:"product_id"`
+	Tool         string    `json:"tool"`
+	ReportLength int       `json:"report_length"`
+	Signer       string    `json:"signer,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+var (
+	ProductColumns    = []string{"PRODUCT", "NAME", "TYPE", "PROJECT", "AUTHOR", "CREATED"}
+	FindingColumns    = []string{"ID", "PRODUCT", "LEVEL", "STATUS", "CWE", "CVE", "RULE", "LOCATION", "TEXT"}
+	EngagementColumns = []string{"ID", "PRODUCT", "TOOL", "SIZE", "CREATED"}
+)
+
+func (p Product) Row() []string {
+	return []string{p.ProductID, p.Name, p.Type, p.Project, p.Author, p.CreatedAt.Format(time.RFC3339)}
+}
+
+func (f Finding) Row() []string {
+	status := f.Status
+	if status == "" {
+		status = "open"
+	}
+	return []string{strconv.FormatUint(uint64(f.ID), 10), f.ProductID, f.Level, status, f.CWE, f.CVE, f.VulnerabilityID, f.LocationHash, f.Text}
+}
+
+func (e Engagement) Row() []string {
+	return []string{strconv.FormatUint(uint64(e.ID), 10), e.ProductID, e.Tool, strconv.Itoa(e.ReportLength), e.CreatedAt.Format(time.RFC3339)}
+}
+
+// Products lists the products, the query narrows them down, e.g. by id
+func Products(client ASPMClientInterface, query url.Values) ([]Product, error) {
+	return List[Product](client, "/ui/product", query)
+}
+
+// Findings lists the findings, the query narrows them down by product, project, tool, level, CWE, CVE or status
+func Findings(client ASPMClientInterface, query url.Values) ([]Finding, error) {
+	return List[Finding](client, "/ui/vulnerability", query)
+}
+
+// Engagements lists the uploaded reports, the query narrows them down by product, project or tool
+func Engagements(client ASPMClientInterface, query url.Values) ([]Engagement, error) {
+	return List[Engagement](client, "/ui/engagement", query)
+}
+
+// List fetches every page of the list endpoint, clients unable to follow cursors get the first page only
+func List[T any](client ASPMClientInterface, endpoint string, query url.Values) ([]T, error) {
+	rows := []T{}
+	paged, ok := client.(PagedClient)
+	if !ok {
+		return rows, client.Get(endpoint, query, &rows)
+	}
+
+	next := url.Values{}
+	for key, values := range query {
+		next[key] = values
+	}
+	for {
+		var page []T
+		cursor, err := paged.GetPage(endpoint, next, &page)
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, page...)
+		if cursor == "" {
+			return rows, nil
+		}
+		next.Set("cursor", cursor)
+	}
+}
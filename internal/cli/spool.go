@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const spoolExtension = ".json"
+
+// SpoolEntry is a complete upload kept on disk until it can be delivered
+type SpoolEntry struct {
+	Endpoint  string          `json:"endpoint"`
+	Payload   json.RawMessage `json:"payload"`
+	SpooledAt time.Time       `json:"spooled_at"`
+}
+
+// Spool writes the payload for the endpoint into the spool directory and returns the created file path.
+// File names start with a timestamp, so lexical order of the directory is the order of uploads.
+func Spool(dir string, endpoint string, data interface{}) (string, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal data: %w", err)
+	}
+
+	entry := SpoolEntry{
+		Endpoint:  endpoint,
+		Payload:   payload,
+		SpooledAt: time.Now().UTC(),
+	}
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal spool entry: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	// Write into a temporary file first, so an interrupted run never leaves a truncated entry behind
+	prefix := entry.SpooledAt.Format("20060102T150405.000000000") + "-" + strings.Trim(endpoint, "/") + "-"
+	file, err := os.CreateTemp(dir, prefix+"*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write spool file: %w", err)
+	}
+
+	path := strings.TrimSuffix(file.Name(), ".tmp") + spoolExtension
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to finalize spool file: %w", err)
+	}
+
+	return path, nil
+}
+
+// SpoolEntries returns paths of all spooled uploads in the order they were made
+func SpoolEntries(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != spoolExtension {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// ReadSpoolEntry loads a spooled upload from the file
+func ReadSpoolEntry(path string) (SpoolEntry, error) {
+	var entry SpoolEntry
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return entry, fmt.Errorf("invalid spool entry: %w", err)
+	}
+
+	if entry.Endpoint == "" || len(entry.Payload) == 0 {
+		return entry, fmt.Errorf("invalid spool entry: endpoint or payload is missing")
+	}
+
+	return entry, nil
+}
@@ -3,19 +3,21 @@ package cli
 import (
 	"encoding/base64"
 	"fmt"
+	"github.com/b4bay/aspm/internal/shared"
 	"os"
 	"os/exec"
 	"strings"
 )
 
-func GetEnvironment() map[string]string {
+// GetEnvironment returns CI metadata allowed by the filter, never the whole environment of the job
+func GetEnvironment(filter shared.EnvironmentFilter) map[string]string {
 	var variablesMap = map[string]string{}
 	for _, e := range os.Environ() {
 		pair := strings.SplitN(e, "=", 2)
 		variablesMap[pair[0]] = pair[1]
 	}
 
-	return variablesMap
+	return filter.Apply(variablesMap)
 }
 
 func GetReports(paths []string) map[string]string {
@@ -64,3 +66,15 @@ func GetAuthorFromGit() string {
 
 	return author
 }
+
+// GetUserFromGit returns who is working in the repository, as configured for their commits
+func GetUserFromGit() string {
+	cmd := exec.Command("git", "config", "user.name")
+	cmd.Dir = "."
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProductAlias is another identifier of the same artefact, e.g. "sha256:<hex>" of a binary whose product id is its SHA-1
+type ProductAlias struct {
+	gorm.Model
+	Alias     string `gorm:"uniqueIndex;not null"`
+	ProductID string `gorm:"index;not null"`
+	// Associations
+	Product Product `gorm:"constraint:OnDelete:CASCADE;foreignKey:ProductID;references:ProductID"`
+}
+
+// ResolveProductID returns the id of the product known by any of the identifiers, either as its product id or as an alias.
+// The first identifier is returned when none is known yet, so it becomes the id of a new product.
+func ResolveProductID(tx *gorm.DB, id string, aliases ...string) (string, error) {
+	for _, candidate := range append([]string{id}, aliases...) {
+		if candidate == "" {
+			continue
+		}
+
+		var product Product
+		err := tx.Where("product_id = ?", candidate).Take(&product).Error
+		if err == nil {
+			return product.ProductID, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", err
+		}
+
+		var alias ProductAlias
+		err = tx.Where("alias = ?", candidate).Take(&alias).Error
+		if err == nil {
+			return alias.ProductID, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// SaveProductAliases registers the aliases of the product, an alias already taken by another product is left with it
+func SaveProductAliases(tx *gorm.DB, productID string, aliases []string) error {
+	var rows []ProductAlias
+	for _, alias := range aliases {
+		if alias != "" && alias != productID {
+			rows = append(rows, ProductAlias{Alias: alias, ProductID: productID})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// MergeProducts moves everything known about one product to another one and removes it,
+// its id stays resolvable as an alias of the product kept.
+// Findings both products have are kept once, with the statuses of both.
+func MergeProducts(tx *gorm.DB, from string, into string) error {
+	if from == into {
+		return errors.New("cannot merge a product into itself")
+	}
+
+	var source, target Product
+	if err := tx.Where("product_id = ?", from).Take(&source).Error; err != nil {
+		return fmt.Errorf("product '%s': %w", from, err)
+	}
+	if err := tx.Where("product_id = ?", into).Take(&target).Error; err != nil {
+		return fmt.Errorf("product '%s': %w", into, err)
+	}
+
+	// Links, without creating a link of the product to itself or a second link both products have
+	if err := tx.Where("product_id = ? AND origin_id = ?", from, into).Or("product_id = ? AND origin_id = ?", into, from).
+		Delete(&Link{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("product_id = ? AND EXISTS (?)", from, tx.Model(&Link{}).Select("1").Table("links AS kept").
+		Where("kept.product_id = ? AND kept.origin_id = links.origin_id AND kept.type = links.type", into)).
+		Delete(&Link{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("origin_id = ? AND EXISTS (?)", from, tx.Model(&Link{}).Select("1").Table("links AS kept").
+		Where("kept.origin_id = ? AND kept.product_id = links.product_id AND kept.type = links.type", into)).
+		Delete(&Link{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Link{}).Where("product_id = ?", from).Update("product_id", into).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Link{}).Where("origin_id = ?", from).Update("origin_id", into).Error; err != nil {
+		return err
+	}
+
+	// Findings, duplicates hand their statuses over to the finding kept
+	var vulnerabilities []Vulnerability
+	if err := tx.Where("product_id = ?", from).Find(&vulnerabilities).Error; err != nil {
+		return err
+	}
+	for _, v := range vulnerabilities {
+		var existing Vulnerability
+		err := tx.Where("product_id = ? AND vulnerability_id = ? AND location_hash = ?", into, v.VulnerabilityID, v.LocationHash).
+			Take(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Model(&v).Update("product_id", into).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Status{}).Where("vulnerability_id = ?", fmt.Sprint(v.ID)).
+			Update("vulnerability_id", fmt.Sprint(existing.ID)).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Delete(&v).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&Engagement{}).Where("product_id = ?", from).Update("product_id", into).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Receipt{}).Where("product_id = ?", from).Update("product_id", into).Error; err != nil {
+		return err
+	}
+
+	// Files of the same path are the same file when the products are the same artefact
+	if err := tx.Unscoped().Where("product_id = ? AND path IN (?)", from, tx.Model(&ProductFile{}).Select("path").Where("product_id = ?", into)).
+		Delete(&ProductFile{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&ProductFile{}).Where("product_id = ?", from).Update("product_id", into).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&ProductAlias{}).Where("product_id = ?", from).Update("product_id", into).Error; err != nil {
+		return err
+	}
+
+	// Fill the gaps of the product kept
+	if target.Name == "" {
+		target.Name = source.Name
+	}
+	if target.Type == "" {
+		target.Type = source.Type
+	}
+	if target.Project == "" {
+		target.Project = source.Project
+	}
+	if target.Ref == "" {
+		target.Ref = source.Ref
+	}
+	if target.Author == "" {
+		target.Author = source.Author
+	}
+	if target.Worker == "" {
+		target.Worker = source.Worker
+	}
+	target.SetPipeline(source.CIContext())
+	if target.BuildID == "" {
+		target.BuildID = source.BuildID
+	}
+	if target.Purl == "" {
+		target.Purl, target.PurlBase = source.Purl, source.PurlBase
+	}
+	if target.CPE == "" {
+		target.CPE = source.CPE
+	}
+	// Either build from a modified source tree makes the artefact one
+	if source.Dirty {
+		target.Dirty = true
+	}
+	if err := tx.Save(&target).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Unscoped().Delete(&source).Error; err != nil {
+		return err
+	}
+
+	return SaveProductAliases(tx, into, []string{from})
+}
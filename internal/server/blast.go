@@ -0,0 +1,107 @@
+package server
+
+import (
+	"github.com/b4bay/aspm/internal/shared"
+	"gorm.io/gorm"
+)
+
+// AffectedByCVE returns the products with findings of the vulnerability, e.g. reported by an image scanner
+func AffectedByCVE(tx *gorm.DB, cve string) ([]string, error) {
+	var productIDs []string
+	err := tx.Model(&Vulnerability{}).Distinct("product_id").Where("cve = ?", cve).Order("product_id").Pluck("product_id", &productIDs).Error
+	return productIDs, err
+}
+
+// AffectedByPurl returns the products with the package URL, qualifiers ignored. A purl without version matches every version.
+func AffectedByPurl(tx *gorm.DB, purl shared.PackageURL) ([]string, error) {
+	query := tx.Model(&Product{}).Where("purl_base = ?", purl.Base())
+	if purl.Version == "" {
+		query = tx.Model(&Product{}).Where("purl_base = ? OR purl_base LIKE ?", purl.Base(), purl.Base()+"@%")
+	}
+	var productIDs []string
+	err := query.Order("product_id").Pluck("product_id", &productIDs).Error
+	return productIDs, err
+}
+
+// AffectedByFinding returns the product of the finding with its stripped or debug twins, the finding applies to all of them
+func AffectedByFinding(tx *gorm.DB, id uint) ([]string, error) {
+	var vulnerability Vulnerability
+	if err := tx.Select("id, product_id").Take(&vulnerability, id).Error; err != nil {
+		return nil, err
+	}
+	return StripTwinIDs(tx, vulnerability.ProductID)
+}
+
+// BlastRadius walks the links forward from the affected products, to everything made of them. Each product is reached
+// by a shortest path, a level of links at a time, and listed once with the path from the affected product it was reached from.
+// The walk is not bounded by depth, a product reached already is not walked again so it ends with cycles too.
+func BlastRadius(tx *gorm.DB, affectedIDs []string) (BlastRadiusResponse, error) {
+	radius := BlastRadiusResponse{Affected: []BlastProductResponse{}, Products: []BlastProductResponse{}}
+	paths := map[string][]BlastStepResponse{}
+	var frontier []string
+	for _, id := range affectedIDs {
+		if paths[id] == nil {
+			paths[id] = []BlastStepResponse{{ProductID: id}}
+			frontier = append(frontier, id)
+		}
+	}
+	affected := frontier
+
+	var reached []string
+	for len(frontier) > 0 {
+		var links []Link
+		if err := tx.Where("origin_id IN ?", frontier).Order("id").Find(&links).Error; err != nil {
+			return radius, err
+		}
+		frontier = nil
+		for _, link := range links {
+			if paths[link.ProductID] != nil {
+				continue
+			}
+			path := append([]BlastStepResponse{}, paths[link.OriginID]...)
+			paths[link.ProductID] = append(path, BlastStepResponse{ProductID: link.ProductID, Method: link.Type})
+			frontier = append(frontier, link.ProductID)
+			reached = append(reached, link.ProductID)
+		}
+	}
+
+	// Products nothing is made of are the ends of the chains, what is shipped
+	all := append(append([]string{}, affected...), reached...)
+	var products []Product
+	if err := tx.Where("product_id IN ?", all).Find(&products).Error; err != nil {
+		return radius, err
+	}
+	var origins []string
+	if err := tx.Model(&Link{}).Distinct("origin_id").Where("origin_id IN ?", all).Pluck("origin_id", &origins).Error; err != nil {
+		return radius, err
+	}
+	notLeaf := map[string]bool{}
+	for _, id := range origins {
+		notLeaf[id] = true
+	}
+
+	known := map[string]Product{}
+	for _, product := range products {
+		known[product.ProductID] = product
+	}
+	response := func(id string) BlastProductResponse {
+		product := known[id]
+		return BlastProductResponse{
+			ProductID: id,
+			Name:      product.Name,
+			Type:      product.Type,
+			Project:   product.Project,
+			Ref:       product.Ref,
+			Leaf:      !notLeaf[id],
+			Path:      paths[id],
+		}
+	}
+	for _, id := range affected {
+		radius.Affected = append(radius.Affected, response(id))
+	}
+	for _, id := range reached {
+		radius.Products = append(radius.Products, response(id))
+	}
+
+	return radius, nil
+}
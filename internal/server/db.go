@@ -22,7 +22,7 @@ func InitDB() error {
 		return err
 	}
 
-	err = DB.AutoMigrate(&Product{}, &Link{}, &Engagement{}, &Vulnerability{}, &Status{})
+	err = DB.AutoMigrate(&Product{}, &Link{}, &Engagement{}, &Vulnerability{}, &Status{}, &Receipt{}, &ProductFile{}, &ProductAlias{}, &SigningKey{})
 
 	return err
 }
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"gorm.io/gorm"
+)
+
+// ProductDetail gathers everything known about the product in one answer. Findings are the ones of the product
+// and its stripped or debug twins, same as listed by UIVulnerabilityHandler.
+func ProductDetail(tx *gorm.DB, productID string) (ProductDetailResponse, error) {
+	var detail ProductDetailResponse
+
+	var product Product
+	if err := tx.Preload("Aliases").Where("product_id = ?", productID).Take(&product).Error; err != nil {
+		return detail, err
+	}
+	detail.ProductResponse = newProductResponse(product)
+	detail.CI = product.CIContext()
+
+	// Latest engagement of each tool
+	var engagements []engagementRow
+	if err := tx.Model(&Engagement{}).Select(engagementColumns).
+		Where("id IN (?)", tx.Model(&Engagement{}).Select("MAX(id)").Where("product_id = ?", productID).Group("tool")).
+		Order("tool").Find(&engagements).Error; err != nil {
+		return detail, err
+	}
+	detail.Engagements = []EngagementResponse{}
+	for _, engagement := range engagements {
+		detail.Engagements = append(detail.Engagements, newEngagementResponse(engagement))
+	}
+
+	productIDs, err := StripTwinIDs(tx, productID)
+	if err != nil {
+		return detail, err
+	}
+	var vulnerabilities []Vulnerability
+	if err := tx.Select("id, level").Where("product_id IN ?", productIDs).Find(&vulnerabilities).Error; err != nil {
+		return detail, err
+	}
+	var ids []uint
+	for _, vulnerability := range vulnerabilities {
+		ids = append(ids, vulnerability.ID)
+	}
+	statuses, err := LatestStatuses(tx, ids)
+	if err != nil {
+		return detail, err
+	}
+	detail.Findings = FindingCountsResponse{Total: len(vulnerabilities), ByLevel: map[string]int{}, ByStatus: map[string]int{}}
+	for _, vulnerability := range vulnerabilities {
+		detail.Findings.ByLevel[string(vulnerability.Level)]++
+		status := string(statuses[vulnerability.ID].Kind)
+		if status == "" {
+			status = "open"
+		}
+		detail.Findings.ByStatus[status]++
+	}
+
+	// Direct neighbours only, nodes with more lineage beyond are marked truncated
+	lineage, err := Lineage(tx, productID, true, true, 1)
+	if err != nil {
+		return detail, err
+	}
+	detail.Origins = append([]LineageNodeResponse{}, lineage.Origins...)
+	detail.Products = append([]LineageNodeResponse{}, lineage.Products...)
+
+	return detail, nil
+}
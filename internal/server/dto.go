@@ -11,6 +11,7 @@ type EngagementResponse struct {
 	ProductID    string    `json:"product_id"`
 	Tool         string    `json:"tool"`
 	ReportLength int       `json:"report_length"`
+	Signer       string    `json:"signer,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
@@ -20,16 +21,40 @@ type ProductResponse struct {
 	Name      string              `json:"name"`
 	Type      shared.ArtefactType `json:"type"`
 	Project   string              `json:"project"`
+	Ref       string              `json:"ref,omitempty"`
 	Author    string              `json:"author"`
 	Worker    string              `json:"worker"`
+	Dirty     bool                `json:"dirty,omitempty"`
+	BuildID   string              `json:"build_id,omitempty"`
+	Purl      string              `json:"purl,omitempty"`
+	CPE       string              `json:"cpe,omitempty"`
+	Aliases   []string            `json:"aliases,omitempty"`
 	CreatedAt time.Time           `json:"created_at"`
 }
 
+// ProductDetailResponse is one product with its CI context, findings and direct lineage, see ProductDetail
+type ProductDetailResponse struct {
+	ProductResponse
+	CI          shared.CIContext      `json:"ci"`
+	Engagements []EngagementResponse  `json:"engagements"` // Latest one of each tool
+	Findings    FindingCountsResponse `json:"findings"`
+	Origins     []LineageNodeResponse `json:"origins"`
+	Products    []LineageNodeResponse `json:"products"`
+}
+
+// FindingCountsResponse counts findings by level and by the status in effect, "open" for findings without one
+type FindingCountsResponse struct {
+	Total    int            `json:"total"`
+	ByLevel  map[string]int `json:"by_level"`
+	ByStatus map[string]int `json:"by_status"`
+}
+
 type LinkResponse struct {
 	ID        uint                    `json:"id"`
 	ProductID string                  `json:"product_id"`
 	OriginID  string                  `json:"origin_id"`
 	Type      shared.ProductionMethod `json:"type"`
+	Signer    string                  `json:"signer,omitempty"`
 	CreatedAt time.Time               `json:"created_at"`
 }
 
@@ -46,6 +71,55 @@ type VulnerabilityResponse struct {
 	Text            string      `json:"text"`
 	CWE             string      `json:"cwe"`
 	CVE             string      `json:"cve"`
+	FileHash        string      `json:"file_hash,omitempty"`
+	Fingerprint     string      `json:"fingerprint"`      // Same for the finding in every product, see Vulnerability.Fingerprint
+	Status          string      `json:"status,omitempty"` // Latest decision, empty for open findings
+	StatusReason    string      `json:"status_reason,omitempty"`
 	EngagementID    uint        `json:"engagement_id"`
 	CreatedAt       time.Time   `json:"created_at"`
 }
+
+// LineageNodeResponse is a product in the lineage of another one, the method is the one of the link to the node it hangs from
+type LineageNodeResponse struct {
+	ProductID    string                  `json:"product_id"`
+	Name         string                  `json:"name"`
+	Type         shared.ArtefactType     `json:"type"`
+	Method       shared.ProductionMethod `json:"method,omitempty"`
+	OpenFindings int64                   `json:"open_findings"`
+	Origins      []LineageNodeResponse   `json:"origins,omitempty"`  // What the product was made of, walking up
+	Products     []LineageNodeResponse   `json:"products,omitempty"` // What was made of the product, walking down
+	Truncated    bool                    `json:"truncated,omitempty"`
+	Repeated     bool                    `json:"repeated,omitempty"` // Reached by another link too, its lineage is shown there
+}
+
+// GateResponse is the comparison of the findings of a product with its baseline, without one every finding is introduced
+type GateResponse struct {
+	ProductID  string                  `json:"product_id"`
+	BaselineID string                  `json:"baseline_id,omitempty"`
+	Introduced []VulnerabilityResponse `json:"introduced"`
+	Fixed      []VulnerabilityResponse `json:"fixed"`
+	Unchanged  []VulnerabilityResponse `json:"unchanged"`
+	Evidence   []EngagementResponse    `json:"evidence"` // Engagements the findings come from
+}
+
+// BlastRadiusResponse is what is made of the affected products, Products are listed nearest first
+type BlastRadiusResponse struct {
+	Affected []BlastProductResponse `json:"affected"`
+	Products []BlastProductResponse `json:"products"`
+}
+
+type BlastProductResponse struct {
+	ProductID string              `json:"product_id"`
+	Name      string              `json:"name"`
+	Type      shared.ArtefactType `json:"type"`
+	Project   string              `json:"project"`
+	Ref       string              `json:"ref,omitempty"`
+	Leaf      bool                `json:"leaf"` // Nothing is made of the product, it is shipped as it is
+	Path      []BlastStepResponse `json:"path"` // From the affected product to this one
+}
+
+// BlastStepResponse is a product on the path, the method is the one of the link it was reached by
+type BlastStepResponse struct {
+	ProductID string                  `json:"product_id"`
+	Method    shared.ProductionMethod `json:"method,omitempty"`
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/b4bay/aspm/internal/server/sarif"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"time"
 )
 
 type Engagement struct {
@@ -11,11 +12,26 @@ type Engagement struct {
 	ProductID string `gorm:"index;not null"`
 	Tool      string
 	RawReport string
+	Signer    string // Name of the registered key the upload was signed with, empty when unsigned
+	ReceiptID uint   `gorm:"index"` // Upload the engagement came with
 	report    *sarif.Report
 	// Associations
 	Product Product `gorm:"constraint:OnDelete:CASCADE;foreignKey:ProductID;references:ProductID"`
 }
 
+// engagementColumns select an engagementRow
+const engagementColumns = "id, product_id, tool, signer, created_at, LENGTH(raw_report) AS report_length"
+
+// engagementRow is an engagement as listed, the raw report is left in the database and only its length is read
+type engagementRow struct {
+	ID           uint
+	ProductID    string
+	Tool         string
+	Signer       string
+	ReportLength int
+	CreatedAt    time.Time
+}
+
 func (e *Engagement) AfterFind(db *gorm.DB) (err error) {
 	return e.parseRawReport()
 }
@@ -45,6 +61,12 @@ func (e *Engagement) Process(tx *gorm.DB) (err error) {
 		return err
 	}
 
+	files, err := ProductFileHashes(tx, e.ProductID)
+	if err != nil {
+		return err
+	}
+
+	var found []Vulnerability
 	for _, run := range e.report.Runs {
 		for _, result := range run.Results {
 			var v Vulnerability
@@ -55,16 +77,24 @@ func (e *Engagement) Process(tx *gorm.DB) (err error) {
 			v.Text = result.Message.Text
 			v.CWE = run.CWE(&result)
 			v.CVE = run.CVE(&result)
+			v.FileHash = FileHashForUri(files, result.LocationUri())
+			v.Tool = run.Tool.Driver.Name
+			v.ToolFingerprint = result.Fingerprint()
 			v.EngagementID = e.ID
 
-			if r := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&v); r.Error != nil {
-				return err
+			r := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&v)
+			if r.Error != nil {
+				return r.Error
+			}
+			if r.RowsAffected == 1 {
+				found = append(found, v)
 			}
 
 		}
 	}
 
-	return nil
+	// Findings seen for the first time may be decided on already along the lineage
+	return InheritStatuses(tx, e.ProductID, found)
 }
 
 func (e *Engagement) Report() *sarif.Report {
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"strings"
+)
+
+// ProductFile is a file of a dir artefact with the SHA-256 of its content, as hashed by the CLI
+type ProductFile struct {
+	gorm.Model
+	ProductID string `gorm:"uniqueIndex:product_file;not null"`
+	Path      string `gorm:"uniqueIndex:product_file;not null"` // Relative to the artefact root, slash separated
+	Hash      string `gorm:"not null"`
+	// Associations
+	Product Product `gorm:"constraint:OnDelete:CASCADE;foreignKey:ProductID;references:ProductID"`
+}
+
+// SaveProductFiles stores the file hashes of the product, files already known are kept as is
+func SaveProductFiles(tx *gorm.DB, productID string, files map[string]string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	var rows []ProductFile
+	for path, hash := range files {
+		rows = append(rows, ProductFile{ProductID: productID, Path: path, Hash: hash})
+	}
+
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&rows, 100).Error
+}
+
+// ProductFileHashes returns the file hashes of the product by path, empty for anything but dir artefacts
+func ProductFileHashes(tx *gorm.DB, productID string) (map[string]string, error) {
+	var rows []ProductFile
+	if err := tx.Where("product_id = ?", productID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string, len(rows))
+	for _, row := range rows {
+		files[row.Path] = row.Hash
+	}
+	return files, nil
+}
+
+// FileHashForUri finds the file a SARIF location points to. Scanners report paths relative to the
+// scanned directory, or absolute ones, so the longest known path the uri ends with is taken.
+// The files are looked up by path, one lookup per directory of the uri.
+func FileHashForUri(files map[string]string, uri string) string {
+	if len(files) == 0 || uri == "" {
+		return ""
+	}
+
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		uri = u.Path
+	}
+	uri = strings.TrimPrefix(uri, "./")
+
+	// Dropping a directory at a time, the first known path is the longest one
+	for suffix := uri; suffix != ""; {
+		if hash, ok := files[suffix]; ok {
+			return hash
+		}
+		_, suffix, _ = strings.Cut(suffix, "/")
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+// Fingerprint identifies the finding across products by the fingerprint its tool gives, else by its rule, file and message,
+// not its lines, so a finding moved by unrelated changes of the file is still the same one.
+// The tool is part of it, tools share rule ids and a finding of each is a finding of its own.
+func (v Vulnerability) Fingerprint() string {
+	key := "fingerprint\x00" + v.ToolFingerprint
+	if v.ToolFingerprint == "" {
+		path, _, _ := strings.Cut(v.LocationHash, "(")
+		key = v.VulnerabilityID + "\x00" + path + "\x00" + v.Text
+	}
+	sum := sha256.Sum256([]byte(v.Tool + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Comparison sorts the findings of a product against the ones of its baseline
+type Comparison struct {
+	Introduced []Vulnerability // Only in the product
+	Fixed      []Vulnerability // Only in the baseline
+	Unchanged  []Vulnerability // In both, as found in the product
+}
+
+// ResolveBaseline finds the product to compare the product with: the one known by the id,
+// else the latest scanned product of the same project and type built from the ref, e.g. the target branch of a merge request
+func ResolveBaseline(tx *gorm.DB, product Product, baseline string) (Product, error) {
+	var found Product
+	productID, err := ResolveProductID(tx, baseline)
+	if err != nil {
+		return found, err
+	}
+	err = tx.Where("product_id = ?", productID).Take(&found).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) || product.Project == "" {
+		return found, err
+	}
+
+	ref := strings.TrimPrefix(strings.TrimPrefix(baseline, "refs/heads/"), "origin/")
+	err = tx.Where("project = ? AND ref = ? AND type = ? AND product_id <> ?", product.Project, ref, product.Type, product.ProductID).
+		Where("product_id IN (?)", tx.Model(&Engagement{}).Select("product_id")).
+		Order("created_at DESC, id DESC").Take(&found).Error
+	return found, err
+}
+
+// CompareFindings compares the findings of the products, the ones of stripped or debug twins included.
+// Findings sharing a fingerprint are paired one to one, so a second copy of a known finding is introduced.
+func CompareFindings(tx *gorm.DB, productID string, baselineID string) (Comparison, error) {
+	var comparison Comparison
+	current, err := twinFindings(tx, productID)
+	if err != nil {
+		return comparison, err
+	}
+	baseline := map[string][]Vulnerability{}
+	if baselineID != "" {
+		previous, err := twinFindings(tx, baselineID)
+		if err != nil {
+			return comparison, err
+		}
+		for _, v := range previous {
+			baseline[v.Fingerprint()] = append(baseline[v.Fingerprint()], v)
+		}
+	}
+
+	for _, v := range current {
+		fingerprint := v.Fingerprint()
+		if len(baseline[fingerprint]) == 0 {
+			comparison.Introduced = append(comparison.Introduced, v)
+			continue
+		}
+		baseline[fingerprint] = baseline[fingerprint][1:]
+		comparison.Unchanged = append(comparison.Unchanged, v)
+	}
+
+	for _, vulnerabilities := range baseline {
+		comparison.Fixed = append(comparison.Fixed, vulnerabilities...)
+	}
+	sort.Slice(comparison.Fixed, func(i, j int) bool { return comparison.Fixed[i].ID < comparison.Fixed[j].ID })
+
+	return comparison, nil
+}
+
+// GateEvidence returns the engagements the findings of the product come from, the ones of stripped or debug twins included,
+// so a gate can require them signed
+func GateEvidence(tx *gorm.DB, productID string) ([]engagementRow, error) {
+	productIDs, err := StripTwinIDs(tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	var engagements []engagementRow
+	err = tx.Model(&Engagement{}).Select(engagementColumns).Where("product_id IN ?", productIDs).Order("id").Find(&engagements).Error
+	return engagements, err
+}
+
+func twinFindings(tx *gorm.DB, productID string) ([]Vulnerability, error) {
+	productIDs, err := StripTwinIDs(tx, productID)
+	if err != nil {
+		return nil, err
+	}
+	var vulnerabilities []Vulnerability
+	err = tx.Where("product_id IN ?", productIDs).Order("id").Find(&vulnerabilities).Error
+	return vulnerabilities, err
+}
@@ -0,0 +1,449 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/b4bay/aspm/internal/server/graphql"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GraphQLMaxCost bounds the objects a GraphQL query may ask for, see graphql.Schema.MaxCost.
+// Set with ASPM_GRAPHQL_MAX_COST, it protects the database from queries nesting big lists.
+var GraphQLMaxCost = graphQLMaxCost(os.Getenv("ASPM_GRAPHQL_MAX_COST"))
+
+// MaxGraphQLBody bounds the size of a posted GraphQL request
+const MaxGraphQLBody = 1 << 20
+
+func graphQLMaxCost(value string) int {
+	if cost, err := strconv.Atoi(value); err == nil && cost > 0 {
+		return cost
+	}
+	return 50000
+}
+
+// GraphQLHandler answers read-only GraphQL queries over projects, products, links, engagements, findings and statuses,
+// posted as JSON or given as ?query=&variables=&operationName=
+func GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var request graphql.Request
+	if r.Method == http.MethodGet {
+		request.Query = r.URL.Query().Get("query")
+		request.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, graphql.Response{Errors: []graphql.Error{{Message: "Invalid variables"}}})
+				return
+			}
+		}
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxGraphQLBody)).Decode(&request); err != nil {
+		writeGraphQL(w, http.StatusBadRequest, graphql.Response{Errors: []graphql.Error{{Message: "Invalid request body"}}})
+		return
+	}
+
+	schema := graphQLSchema()
+	schema.MaxCost = GraphQLMaxCost
+	data, err := schema.Execute(request)
+	var requestErr *graphql.RequestError
+	if errors.As(err, &requestErr) {
+		writeGraphQL(w, http.StatusBadRequest, graphql.Response{Errors: []graphql.Error{{Message: requestErr.Message}}})
+		return
+	} else if err != nil {
+		// The details of the database are kept to the log
+		log.Printf("Error resolving GraphQL query: %v", err)
+		writeGraphQL(w, http.StatusInternalServerError, graphql.Response{Errors: []graphql.Error{{Message: "Failed to resolve query"}}})
+		return
+	}
+	writeGraphQL(w, http.StatusOK, graphql.Response{Data: data})
+}
+
+func writeGraphQL(w http.ResponseWriter, status int, response graphql.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// graphQLSchema maps the models, the ids of products are the product ids and findings are the own ones of a product.
+// Lists nested in objects return 10 items unless asked for more, nested lists multiply the cost of a query.
+func graphQLSchema() *graphql.Schema {
+	return &graphql.Schema{
+		Query:    "Query",
+		MaxFirst: MaxPageLimit,
+		Types: map[string]graphql.Object{
+			"Query": {
+				"product":  {Type: "Product", Args: map[string]string{"id": "String!"}, Resolve: resolveProduct},
+				"products": {Type: "Product", List: true, First: DefaultPageLimit, Args: map[string]string{"project": "String", "type": "String", "ref": "String"}, Resolve: resolveProducts},
+				"project":  {Type: "Project", Args: map[string]string{"name": "String!"}, Resolve: resolveProject},
+				"projects": {Type: "Project", List: true, First: DefaultPageLimit, Resolve: resolveProjects},
+				"finding":  {Type: "Finding", Args: map[string]string{"id": "Int!"}, Resolve: resolveFinding},
+				"findings": {Type: "Finding", List: true, First: DefaultPageLimit, Args: map[string]string{"product": "String", "project": "String", "cve": "String", "level": "String", "status": "String"}, Resolve: resolveFindings},
+			},
+			"Project": {
+				"name":     {Resolve: scalar(func(name string) interface{} { return name })},
+				"products": {Type: "Product", List: true, First: 10, Args: map[string]string{"type": "String"}, Resolve: resolveProjectProducts},
+			},
+			"Product": {
+				"id":          {Resolve: scalar(func(p Product) interface{} { return p.ProductID })},
+				"name":        {Resolve: scalar(func(p Product) interface{} { return p.Name })},
+				"type":        {Resolve: scalar(func(p Product) interface{} { return p.Type })},
+				"project":     {Resolve: scalar(func(p Product) interface{} { return p.Project })},
+				"ref":         {Resolve: scalar(func(p Product) interface{} { return p.Ref })},
+				"revision":    {Resolve: scalar(func(p Product) interface{} { return p.Revision })},
+				"pipeline":    {Resolve: scalar(func(p Product) interface{} { return p.Pipeline })},
+				"author":      {Resolve: scalar(func(p Product) interface{} { return p.Author })},
+				"worker":      {Resolve: scalar(func(p Product) interface{} { return p.Worker })},
+				"dirty":       {Resolve: scalar(func(p Product) interface{} { return p.Dirty })},
+				"buildId":     {Resolve: scalar(func(p Product) interface{} { return p.BuildID })},
+				"purl":        {Resolve: scalar(func(p Product) interface{} { return p.Purl })},
+				"cpe":         {Resolve: scalar(func(p Product) interface{} { return p.CPE })},
+				"createdAt":   {Resolve: scalar(func(p Product) interface{} { return p.CreatedAt })},
+				"aliases":     {List: true, First: 10, Resolve: resolveAliases},
+				"origins":     {Type: "Link", List: true, First: 10, Args: map[string]string{"method": "String"}, Resolve: resolveLinks("product_id")},
+				"products":    {Type: "Link", List: true, First: 10, Args: map[string]string{"method": "String"}, Resolve: resolveLinks("origin_id")},
+				"engagements": {Type: "Engagement", List: true, First: 10, Args: map[string]string{"tool": "String"}, Resolve: resolveEngagements},
+				"findings":    {Type: "Finding", List: true, First: 10, Args: map[string]string{"level": "String", "status": "String"}, Resolve: resolveProductFindings},
+			},
+			"Link": {
+				"method":    {Resolve: scalar(func(l Link) interface{} { return l.Type })},
+				"signer":    {Resolve: scalar(func(l Link) interface{} { return l.Signer })},
+				"createdAt": {Resolve: scalar(func(l Link) interface{} { return l.CreatedAt })},
+				"product":   {Type: "Product", Resolve: resolveProductsByID(func(l Link) string { return l.ProductID })},
+				"origin":    {Type: "Product", Resolve: resolveProductsByID(func(l Link) string { return l.OriginID })},
+			},
+			"Engagement": {
+				"id":           {Resolve: scalar(func(e engagementRow) interface{} { return e.ID })},
+				"tool":         {Resolve: scalar(func(e engagementRow) interface{} { return e.Tool })},
+				"reportLength": {Resolve: scalar(func(e engagementRow) interface{} { return e.ReportLength })},
+				"signer":       {Resolve: scalar(func(e engagementRow) interface{} { return e.Signer })},
+				"createdAt":    {Resolve: scalar(func(e engagementRow) interface{} { return e.CreatedAt })},
+				"product":      {Type: "Product", Resolve: resolveProductsByID(func(e engagementRow) string { return e.ProductID })},
+				"findings":     {Type: "Finding", List: true, First: 10, Args: map[string]string{"level": "String", "status": "String"}, Resolve: resolveEngagementFindings},
+			},
+			"Finding": {
+				"id":          {Resolve: scalar(func(v Vulnerability) interface{} { return v.ID })},
+				"ruleId":      {Resolve: scalar(func(v Vulnerability) interface{} { return v.VulnerabilityID })},
+				"location":    {Resolve: scalar(func(v Vulnerability) interface{} { return v.LocationHash })},
+				"level":       {Resolve: scalar(func(v Vulnerability) interface{} { return v.Level })},
+				"text":        {Resolve: scalar(func(v Vulnerability) interface{} { return v.Text })},
+				"cwe":         {Resolve: scalar(func(v Vulnerability) interface{} { return v.CWE })},
+				"cve":         {Resolve: scalar(func(v Vulnerability) interface{} { return v.CVE })},
+				"fileHash":    {Resolve: scalar(func(v Vulnerability) interface{} { return v.FileHash })},
+				"fingerprint": {Resolve: scalar(func(v Vulnerability) interface{} { return v.Fingerprint() })},
+				"createdAt":   {Resolve: scalar(func(v Vulnerability) interface{} { return v.CreatedAt })},
+				"status":      {Resolve: resolveFindingStatus},
+				"statuses":    {Type: "Status", List: true, First: 10, Resolve: resolveStatuses},
+				"product":     {Type: "Product", Resolve: resolveProductsByID(func(v Vulnerability) string { return v.ProductID })},
+				"engagement":  {Type: "Engagement", Resolve: resolveFindingEngagement},
+			},
+			"Status": {
+				"kind":        {Resolve: scalar(func(s Status) interface{} { return s.Kind })},
+				"propagation": {Resolve: scalar(func(s Status) interface{} { return s.Propagation })},
+				"reason":      {Resolve: scalar(func(s Status) interface{} { return s.Reason })},
+				"author":      {Resolve: scalar(func(s Status) interface{} { return s.Author })},
+				"inherited":   {Resolve: scalar(func(s Status) interface{} { return s.OriginStatusID != 0 })},
+				"createdAt":   {Resolve: scalar(func(s Status) interface{} { return s.CreatedAt })},
+			},
+		},
+	}
+}
+
+// scalar resolves a field of the parents one by one, they are loaded already
+func scalar[T any](value func(T) interface{}) graphql.Resolver {
+	return func(parents []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			values[i] = value(parent.(T))
+		}
+		return values, nil
+	}
+}
+
+func stringArg(args map[string]interface{}, name string) string {
+	value, _ := args[name].(string)
+	return value
+}
+
+// firstPerParent loads the first rows of the query for each parent, the ones with the lowest ids,
+// so a parent with many rows never has them all loaded
+func firstPerParent[T any](query *gorm.DB, columns string, column string, keys []string, first int) ([]T, error) {
+	ranked := query.Select(columns+", ROW_NUMBER() OVER (PARTITION BY "+column+" ORDER BY id) AS row_rank").Where(column+" IN ?", keys)
+	var rows []T
+	err := DB.Table("(?) AS ranked", ranked).Where("row_rank <= ?", first).Order("id").Find(&rows).Error
+	return rows, err
+}
+
+// perParent hands the rows to the parents with the same key, as a list for each parent
+func perParent[P any, T any](parents []interface{}, parentKey func(P) string, rows []T, rowKey func(T) string) []interface{} {
+	byKey := map[string][]interface{}{}
+	for _, row := range rows {
+		byKey[rowKey(row)] = append(byKey[rowKey(row)], row)
+	}
+	values := make([]interface{}, len(parents))
+	for i, parent := range parents {
+		values[i] = byKey[parentKey(parent.(P))]
+	}
+	return values
+}
+
+func parentKeys[P any](parents []interface{}, key func(P) string) []string {
+	keys := make([]string, len(parents))
+	for i, parent := range parents {
+		keys[i] = key(parent.(P))
+	}
+	return keys
+}
+
+func productID(p Product) string {
+	return p.ProductID
+}
+
+func resolveProduct(_ []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	productID, err := ResolveProductID(DB, stringArg(args, "id"))
+	if err != nil {
+		return nil, err
+	}
+	var product Product
+	err = DB.Where("product_id = ?", productID).Take(&product).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return []interface{}{nil}, nil
+	}
+	return []interface{}{product}, err
+}
+
+func resolveProducts(_ []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	query := DB.Model(&Product{})
+	for _, column := range []string{"project", "type", "ref"} {
+		if value := stringArg(args, column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	var products []Product
+	if err := query.Order("id").Limit(args["first"].(int)).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	return []interface{}{toInterfaces(products)}, nil
+}
+
+func resolveProject(_ []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	var count int64
+	name := stringArg(args, "name")
+	if err := DB.Model(&Product{}).Where("project = ?", name).Count(&count).Error; err != nil || count == 0 {
+		return []interface{}{nil}, err
+	}
+	return []interface{}{name}, nil
+}
+
+func resolveProjects(_ []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	var names []string
+	if err := DB.Model(&Product{}).Distinct("project").Where("project <> ''").Order("project").
+		Limit(args["first"].(int)).Pluck("project", &names).Error; err != nil {
+		return nil, err
+	}
+	return []interface{}{toInterfaces(names)}, nil
+}
+
+func resolveProjectProducts(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	query := DB.Model(&Product{})
+	if value := stringArg(args, "type"); value != "" {
+		query = query.Where("type = ?", value)
+	}
+	name := func(name string) string { return name }
+	products, err := firstPerParent[Product](query, "*", "project", parentKeys(parents, name), args["first"].(int))
+	if err != nil {
+		return nil, err
+	}
+	return perParent(parents, name, products, func(p Product) string { return p.Project }), nil
+}
+
+func resolveProductsByID[P any](key func(P) string) graphql.Resolver {
+	return func(parents []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+		var products []Product
+		if err := DB.Where("product_id IN ?", parentKeys(parents, key)).Find(&products).Error; err != nil {
+			return nil, err
+		}
+		byID := map[string]Product{}
+		for _, product := range products {
+			byID[product.ProductID] = product
+		}
+		values := make([]interface{}, len(parents))
+		for i, parent := range parents {
+			if product, found := byID[key(parent.(P))]; found {
+				values[i] = product
+			}
+		}
+		return values, nil
+	}
+}
+
+func resolveAliases(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	aliases, err := firstPerParent[ProductAlias](DB.Model(&ProductAlias{}), "*", "product_id", parentKeys(parents, productID), args["first"].(int))
+	if err != nil {
+		return nil, err
+	}
+	values := perParent(parents, productID, aliases, func(a ProductAlias) string { return a.ProductID })
+	for i, value := range values {
+		list, _ := value.([]interface{})
+		if list == nil {
+			list = []interface{}{}
+		}
+		for j, alias := range list {
+			list[j] = alias.(ProductAlias).Alias
+		}
+		values[i] = list
+	}
+	return values, nil
+}
+
+// resolveLinks lists the links of the products by the column, product_id for their origins and origin_id for what is made of them
+func resolveLinks(column string) graphql.Resolver {
+	return func(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		query := DB.Model(&Link{})
+		if method := stringArg(args, "method"); method != "" {
+			query = query.Where("type = ?", method)
+		}
+		links, err := firstPerParent[Link](query, "*", column, parentKeys(parents, productID), args["first"].(int))
+		if err != nil {
+			return nil, err
+		}
+		return perParent(parents, productID, links, func(l Link) string {
+			if column == "origin_id" {
+				return l.OriginID
+			}
+			return l.ProductID
+		}), nil
+	}
+}
+
+func resolveEngagements(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	query := DB.Model(&Engagement{})
+	if tool := stringArg(args, "tool"); tool != "" {
+		query = query.Where("tool = ?", tool)
+	}
+	engagements, err := firstPerParent[engagementRow](query, engagementColumns, "product_id", parentKeys(parents, productID), args["first"].(int))
+	if err != nil {
+		return nil, err
+	}
+	return perParent(parents, productID, engagements, func(e engagementRow) string { return e.ProductID }), nil
+}
+
+// findingQuery filters findings by the level and status arguments
+func findingQuery(args map[string]interface{}) *gorm.DB {
+	query := DB.Model(&Vulnerability{})
+	if level := stringArg(args, "level"); level != "" {
+		query = query.Where("level = ?", strings.ToLower(level))
+	}
+	if status := stringArg(args, "status"); status != "" {
+		query = WhereStatus(DB, query, status)
+	}
+	return query
+}
+
+func resolveFinding(_ []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	var vulnerability Vulnerability
+	err := DB.Take(&vulnerability, args["id"].(int)).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return []interface{}{nil}, nil
+	}
+	return []interface{}{vulnerability}, err
+}
+
+func resolveFindings(_ []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	query := findingQuery(args)
+	if id := stringArg(args, "product"); id != "" {
+		productID, err := ResolveProductID(DB, id)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("product_id = ?", productID)
+	}
+	if project := stringArg(args, "project"); project != "" {
+		query = query.Where("product_id IN (?)", DB.Model(&Product{}).Select("product_id").Where("project = ?", project))
+	}
+	if cve := stringArg(args, "cve"); cve != "" {
+		query = query.Where("cve = ?", strings.ToUpper(cve))
+	}
+	var vulnerabilities []Vulnerability
+	if err := query.Order("id").Limit(args["first"].(int)).Find(&vulnerabilities).Error; err != nil {
+		return nil, err
+	}
+	return []interface{}{toInterfaces(vulnerabilities)}, nil
+}
+
+func resolveProductFindings(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	vulnerabilities, err := firstPerParent[Vulnerability](findingQuery(args), "*", "product_id", parentKeys(parents, productID), args["first"].(int))
+	if err != nil {
+		return nil, err
+	}
+	return perParent(parents, productID, vulnerabilities, func(v Vulnerability) string { return v.ProductID }), nil
+}
+
+func resolveEngagementFindings(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	engagementID := func(e engagementRow) string { return fmt.Sprint(e.ID) }
+	vulnerabilities, err := firstPerParent[Vulnerability](findingQuery(args), "*", "engagement_id", parentKeys(parents, engagementID), args["first"].(int))
+	if err != nil {
+		return nil, err
+	}
+	return perParent(parents, engagementID, vulnerabilities, func(v Vulnerability) string { return fmt.Sprint(v.EngagementID) }), nil
+}
+
+func resolveFindingStatus(parents []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+	var ids []uint
+	for _, parent := range parents {
+		ids = append(ids, parent.(Vulnerability).ID)
+	}
+	statuses, err := LatestStatuses(DB, ids)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(parents))
+	for i, id := range ids {
+		values[i] = "open"
+		if status, found := statuses[id]; found {
+			values[i] = status.Kind
+		}
+	}
+	return values, nil
+}
+
+func resolveStatuses(parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+	vulnerabilityID := func(v Vulnerability) string { return fmt.Sprint(v.ID) }
+	statuses, err := firstPerParent[Status](DB.Model(&Status{}), "*", "vulnerability_id", parentKeys(parents, vulnerabilityID), args["first"].(int))
+	if err != nil {
+		return nil, err
+	}
+	return perParent(parents, vulnerabilityID, statuses, func(s Status) string { return s.VulnerabilityID }), nil
+}
+
+func resolveFindingEngagement(parents []interface{}, _ map[string]interface{}) ([]interface{}, error) {
+	var ids []uint
+	for _, parent := range parents {
+		ids = append(ids, parent.(Vulnerability).EngagementID)
+	}
+	var engagements []engagementRow
+	if err := DB.Model(&Engagement{}).Select(engagementColumns).Where("id IN ?", ids).Find(&engagements).Error; err != nil {
+		return nil, err
+	}
+	byID := map[uint]engagementRow{}
+	for _, engagement := range engagements {
+		byID[engagement.ID] = engagement
+	}
+	values := make([]interface{}, len(parents))
+	for i, id := range ids {
+		if engagement, found := byID[id]; found {
+			values[i] = engagement
+		}
+	}
+	return values, nil
+}
+
+func toInterfaces[T any](rows []T) []interface{} {
+	values := make([]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = row
+	}
+	return values
+}
@@ -0,0 +1,486 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Schema is the object types of a read-only API, requests start at the Query type.
+// Every field is resolved for all the objects of a level at once, so a nested list costs one lookup per level, not one per object.
+type Schema struct {
+	Query    string
+	Types    map[string]Object
+	MaxFirst int // Most list items a field returns, whatever its first argument
+	MaxCost  int // Most objects a request may ask for, a list counts for as many as its first argument
+}
+
+// Object is a type of the schema by field name
+type Object map[string]*Field
+
+// Resolver returns the value of the field for each parent, in the same order. List fields return a []interface{} for each.
+type Resolver func(parents []interface{}, args map[string]interface{}) ([]interface{}, error)
+
+type Field struct {
+	Type    string            // Object type of the values, empty for scalars
+	List    bool              // List fields take a first argument, at most the First items are returned without it
+	First   int               // Default of the first argument
+	Args    map[string]string // Argument types: String, Int or Boolean, with ! when required
+	Resolve Resolver
+}
+
+// Request is a GraphQL request as posted
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the answer to a request, data is missing when the request failed
+type Response struct {
+	Data   *Map    `json:"data,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+type Error struct {
+	Message string `json:"message"`
+}
+
+// RequestError is a problem of the request itself, as opposed to a failure of a resolver
+type RequestError struct {
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func requestError(format string, args ...interface{}) *RequestError {
+	return &RequestError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Map is an object of the response, its keys are kept in the order of the query
+type Map struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *Map) Set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = map[string]interface{}{}
+	}
+	if _, found := m.values[key]; !found {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *Map) Get(key string) interface{} {
+	return m.values[key]
+}
+
+func (m *Map) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// request is a request being executed
+type request struct {
+	schema    *Schema
+	document  *Document
+	variables map[string]interface{}
+}
+
+// Execute runs the query of the request. Problems of the request are RequestError, anything else is a failure to resolve.
+func (s *Schema) Execute(r Request) (*Map, error) {
+	document, err := Parse(r.Query)
+	if err != nil {
+		return nil, &RequestError{Message: err.Error()}
+	}
+
+	var operation *Operation
+	if r.OperationName == "" {
+		if len(document.Operations) > 1 {
+			return nil, requestError("operation name required, the document has %d operations", len(document.Operations))
+		}
+		operation = document.Operations[0]
+	}
+	for _, o := range document.Operations {
+		if r.OperationName != "" && o.Name == r.OperationName {
+			operation = o
+		}
+	}
+	if operation == nil {
+		return nil, requestError("unknown operation '%s'", r.OperationName)
+	}
+	if operation.Type != "query" {
+		return nil, requestError("only queries are supported, the API is read-only")
+	}
+
+	variables, err := coerceVariables(operation.Variables, r.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{schema: s, document: document, variables: variables}
+	cost, err := req.cost(s.Query, operation.SelectionSet, 0)
+	if err != nil {
+		return nil, err
+	}
+	if cost > s.MaxCost {
+		return nil, requestError("query cost %d exceeds the limit of %d, ask for fewer items with first", cost, s.MaxCost)
+	}
+
+	results, err := req.execute(s.Query, operation.SelectionSet, []interface{}{nil})
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+func coerceVariables(definitions []*VariableDefinition, given map[string]interface{}) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for _, definition := range definitions {
+		value, found := given[definition.Name]
+		if !found || value == nil {
+			value = definition.Default
+		}
+		if value == nil && strings.HasSuffix(definition.Type, "!") {
+			return nil, requestError("variable '$%s' of type %s is required", definition.Name, definition.Type)
+		}
+		variables[definition.Name] = value
+	}
+	return variables, nil
+}
+
+// group is the fields of a selection set sharing a response key, their selections are merged
+type group struct {
+	key    string
+	fields []*Selection
+}
+
+func (g group) selectionSet() []*Selection {
+	var selections []*Selection
+	for _, field := range g.fields {
+		selections = append(selections, field.SelectionSet...)
+	}
+	return selections
+}
+
+// collect flattens the fragments of the selection set into fields grouped by response key, in the order of the query
+func (r *request) collect(typeName string, selections []*Selection, groups []group, visited map[string]bool) ([]group, error) {
+	for _, selection := range selections {
+		include, err := r.included(selection.Directives)
+		if err != nil {
+			return nil, err
+		}
+		if !include {
+			continue
+		}
+
+		switch selection.Kind {
+		case FieldSelection:
+			found := false
+			for i := range groups {
+				if groups[i].key == selection.Key() {
+					if groups[i].fields[0].Name != selection.Name {
+						return nil, requestError("fields '%s' and '%s' conflict on '%s'", groups[i].fields[0].Name, selection.Name, selection.Key())
+					}
+					groups[i].fields = append(groups[i].fields, selection)
+					found = true
+				}
+			}
+			if !found {
+				groups = append(groups, group{key: selection.Key(), fields: []*Selection{selection}})
+			}
+		case FragmentSpread:
+			fragment := r.document.Fragments[selection.Name]
+			if fragment == nil {
+				return nil, requestError("unknown fragment '%s'", selection.Name)
+			}
+			if visited[fragment.Name] {
+				return nil, requestError("fragment '%s' spreads itself", fragment.Name)
+			}
+			if fragment.TypeCondition != typeName {
+				continue
+			}
+			visited[fragment.Name] = true
+			groups, err = r.collect(typeName, fragment.SelectionSet, groups, visited)
+			delete(visited, fragment.Name)
+			if err != nil {
+				return nil, err
+			}
+		case InlineFragment:
+			if selection.TypeCondition != "" && selection.TypeCondition != typeName {
+				continue
+			}
+			if groups, err = r.collect(typeName, selection.SelectionSet, groups, visited); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return groups, nil
+}
+
+// included evaluates the @skip and @include directives
+func (r *request) included(directives []*Directive) (bool, error) {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			return false, requestError("unknown directive '@%s'", directive.Name)
+		}
+		value, err := r.value(directive.Arguments["if"])
+		condition, ok := value.(bool)
+		if err != nil || !ok {
+			return false, requestError("directive '@%s' requires a Boolean 'if' argument", directive.Name)
+		}
+		if condition == (directive.Name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// value replaces the variables in the value by their values
+func (r *request) value(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case Variable:
+		variable, found := r.variables[string(v)]
+		if !found {
+			return nil, requestError("variable '$%s' is not defined", v)
+		}
+		return variable, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if list[i], err = r.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]interface{}:
+		object := map[string]interface{}{}
+		for name, item := range v {
+			var err error
+			if object[name], err = r.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	}
+	return value, nil
+}
+
+// arguments checks the arguments of the field against their types, the first argument of lists is always set
+func (r *request) arguments(typeName string, field *Field, selection *Selection) (map[string]interface{}, error) {
+	types := map[string]string{}
+	for name, t := range field.Args {
+		types[name] = t
+	}
+	if field.List {
+		types["first"] = "Int"
+	}
+
+	args := map[string]interface{}{}
+	for name, given := range selection.Arguments {
+		t, found := types[name]
+		if !found {
+			return nil, requestError("unknown argument '%s' of field '%s.%s'", name, typeName, selection.Name)
+		}
+		value, err := r.value(given)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+
+		switch strings.TrimSuffix(t, "!") {
+		case "String":
+			if _, ok := value.(string); !ok {
+				return nil, requestError("argument '%s' of field '%s.%s' must be a String", name, typeName, selection.Name)
+			}
+		case "Int":
+			// Variables are JSON numbers
+			if number, ok := value.(float64); ok && number == math.Trunc(number) && math.Abs(number) < math.MaxInt32 {
+				value = int(number)
+			}
+			if _, ok := value.(int); !ok {
+				return nil, requestError("argument '%s' of field '%s.%s' must be an Int", name, typeName, selection.Name)
+			}
+		case "Boolean":
+			if _, ok := value.(bool); !ok {
+				return nil, requestError("argument '%s' of field '%s.%s' must be a Boolean", name, typeName, selection.Name)
+			}
+		}
+		args[name] = value
+	}
+
+	for name, t := range types {
+		if _, found := args[name]; !found && strings.HasSuffix(t, "!") {
+			return nil, requestError("argument '%s' of field '%s.%s' is required", name, typeName, selection.Name)
+		}
+	}
+
+	if field.List {
+		first, found := args["first"].(int)
+		if !found {
+			first = field.First
+		}
+		if first < 0 {
+			return nil, requestError("argument 'first' of field '%s.%s' must not be negative", typeName, selection.Name)
+		}
+		args["first"] = min(first, r.schema.MaxFirst)
+	}
+	return args, nil
+}
+
+// cost is the number of objects the selection set may return at most, a list counts for as many objects as its first argument
+func (r *request) cost(typeName string, selections []*Selection, depth int) (int, error) {
+	if depth > MaxDepth {
+		return 0, requestError("query is nested too deep")
+	}
+	groups, err := r.collect(typeName, selections, nil, map[string]bool{})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	for _, g := range groups {
+		selection := g.fields[0]
+		if selection.Name == "__typename" {
+			continue
+		}
+		field := r.schema.Types[typeName][selection.Name]
+		if field == nil {
+			return 0, requestError("unknown field '%s' of type %s", selection.Name, typeName)
+		}
+		args, err := r.arguments(typeName, field, selection)
+		if err != nil {
+			return 0, err
+		}
+
+		children := g.selectionSet()
+		if field.Type == "" {
+			if len(children) > 0 {
+				return 0, requestError("field '%s.%s' is a scalar, it has no fields", typeName, selection.Name)
+			}
+			continue
+		}
+		if len(children) == 0 {
+			return 0, requestError("field '%s.%s' of type %s needs a selection of its fields", typeName, selection.Name, field.Type)
+		}
+
+		childCost, err := r.cost(field.Type, children, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		count := 1
+		if field.List {
+			count = args["first"].(int)
+		}
+		// Saturated, it is compared with the limit only
+		total = min(total+count*(1+childCost), math.MaxInt32)
+	}
+	return total, nil
+}
+
+// execute resolves the selection set for all the parents, each field once for all of them
+func (r *request) execute(typeName string, selections []*Selection, parents []interface{}) ([]*Map, error) {
+	results := make([]*Map, len(parents))
+	if len(parents) == 0 {
+		return results, nil
+	}
+	for i := range results {
+		results[i] = &Map{}
+	}
+	groups, err := r.collect(typeName, selections, nil, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		selection := g.fields[0]
+		if selection.Name == "__typename" {
+			for _, result := range results {
+				result.Set(g.key, typeName)
+			}
+			continue
+		}
+
+		field := r.schema.Types[typeName][selection.Name]
+		args, err := r.arguments(typeName, field, selection)
+		if err != nil {
+			return nil, err
+		}
+		values, err := field.Resolve(parents, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve '%s.%s': %w", typeName, selection.Name, err)
+		}
+		if len(values) != len(parents) {
+			return nil, fmt.Errorf("failed to resolve '%s.%s': %d values for %d objects", typeName, selection.Name, len(values), len(parents))
+		}
+
+		if field.Type == "" {
+			for i, result := range results {
+				result.Set(g.key, values[i])
+			}
+			continue
+		}
+
+		// Objects of every parent are resolved together, then handed back to their parent
+		var children []interface{}
+		var counts []int
+		for _, value := range values {
+			items := []interface{}{value}
+			if field.List {
+				items, _ = value.([]interface{})
+				items = items[:min(len(items), args["first"].(int))]
+			}
+			count := 0
+			for _, item := range items {
+				if item != nil {
+					children = append(children, item)
+					count++
+				}
+			}
+			counts = append(counts, count)
+		}
+		objects, err := r.execute(field.Type, g.selectionSet(), children)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, result := range results {
+			switch {
+			case field.List:
+				list := make([]*Map, counts[i])
+				copy(list, objects)
+				result.Set(g.key, list)
+			case counts[i] == 1:
+				result.Set(g.key, objects[0])
+			default:
+				result.Set(g.key, nil)
+			}
+			objects = objects[counts[i]:]
+		}
+	}
+	return results, nil
+}
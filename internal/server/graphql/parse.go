@@ -0,0 +1,518 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed GraphQL request document
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+type Operation struct {
+	Type         string // query, mutation or subscription
+	Name         string
+	Variables    []*VariableDefinition
+	SelectionSet []*Selection
+}
+
+type VariableDefinition struct {
+	Name    string
+	Type    string // As written, e.g. [String!]!
+	Default interface{}
+}
+
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	SelectionSet  []*Selection
+}
+
+type SelectionKind int
+
+const (
+	FieldSelection SelectionKind = iota
+	FragmentSpread
+	InlineFragment
+)
+
+// Selection is a field, a spread of a named fragment or an inline fragment
+type Selection struct {
+	Kind          SelectionKind
+	Alias         string // Fields only, empty unless aliased
+	Name          string // Name of the field or of the spread fragment
+	TypeCondition string // Inline fragments only, empty for any type
+	Arguments     map[string]interface{}
+	Directives    []*Directive
+	SelectionSet  []*Selection
+}
+
+type Directive struct {
+	Name      string
+	Arguments map[string]interface{}
+}
+
+// Variable is a reference to a variable in a value, enum values are plain strings
+type Variable string
+
+// Key is the name of the field in the response
+func (s *Selection) Key() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind   tokenKind
+	value  string
+	offset int
+}
+
+// MaxDepth bounds the nesting of selection sets, values and types, the parser and the executor recurse on them
+const MaxDepth = 32
+
+type parser struct {
+	source string
+	offset int
+	token  token
+	depth  int
+}
+
+// Parse parses the executable definitions of the document: operations and fragments, block strings are not supported
+func Parse(source string) (doc *Document, err error) {
+	p := &parser{source: strings.TrimPrefix(source, "\ufeff")}
+	defer func() {
+		if r := recover(); r != nil {
+			syntax, ok := r.(syntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, syntax
+		}
+	}()
+
+	p.next()
+	doc = &Document{Fragments: map[string]*Fragment{}}
+	for p.token.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", SelectionSet: p.parseSelectionSet()})
+		case p.peekName("query"), p.peekName("mutation"), p.peekName("subscription"):
+			doc.Operations = append(doc.Operations, p.parseOperation())
+		case p.peekName("fragment"):
+			fragment := p.parseFragment()
+			if doc.Fragments[fragment.Name] != nil {
+				return nil, fmt.Errorf("fragment '%s' is defined twice", fragment.Name)
+			}
+			doc.Fragments[fragment.Name] = fragment
+		default:
+			p.fail("unexpected %s", p.describe())
+		}
+	}
+
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("document has no operation")
+	}
+	return doc, nil
+}
+
+type syntaxError struct {
+	message string
+	line    int
+	column  int
+}
+
+func (e syntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.line, e.column, e.message)
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	before := p.source[:p.token.offset]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+	panic(syntaxError{message: fmt.Sprintf(format, args...), line: line, column: column})
+}
+
+func (p *parser) describe() string {
+	if p.token.kind == tokenEOF {
+		return "end of document"
+	}
+	return fmt.Sprintf("'%s'", p.token.value)
+}
+
+// nest enters a level of nesting and returns the way out of it, a document nested too deep is a syntax error
+func (p *parser) nest() func() {
+	if p.depth++; p.depth > MaxDepth {
+		p.fail("document is nested deeper than %d levels", MaxDepth)
+	}
+	return func() { p.depth-- }
+}
+
+func (p *parser) peek(punctuator string) bool {
+	return p.token.kind == tokenPunctuator && p.token.value == punctuator
+}
+
+func (p *parser) peekName(name string) bool {
+	return p.token.kind == tokenName && p.token.value == name
+}
+
+func (p *parser) expect(punctuator string) {
+	if !p.peek(punctuator) {
+		p.fail("expected '%s', got %s", punctuator, p.describe())
+	}
+	p.next()
+}
+
+func (p *parser) skip(punctuator string) bool {
+	if p.peek(punctuator) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) name() string {
+	if p.token.kind != tokenName {
+		p.fail("expected a name, got %s", p.describe())
+	}
+	name := p.token.value
+	p.next()
+	return name
+}
+
+func (p *parser) parseOperation() *Operation {
+	operation := &Operation{Type: p.name()}
+	if p.token.kind == tokenName {
+		operation.Name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect("$")
+			definition := &VariableDefinition{Name: p.name()}
+			p.expect(":")
+			definition.Type = p.parseType()
+			if p.skip("=") {
+				definition.Default = p.parseValue(true)
+			}
+			operation.Variables = append(operation.Variables, definition)
+		}
+	}
+	p.parseDirectives()
+	operation.SelectionSet = p.parseSelectionSet()
+	return operation
+}
+
+func (p *parser) parseFragment() *Fragment {
+	p.next()
+	fragment := &Fragment{Name: p.name()}
+	if fragment.Name == "on" {
+		p.fail("fragment cannot be named 'on'")
+	}
+	if !p.peekName("on") {
+		p.fail("expected 'on', got %s", p.describe())
+	}
+	p.next()
+	fragment.TypeCondition = p.name()
+	p.parseDirectives()
+	fragment.SelectionSet = p.parseSelectionSet()
+	return fragment
+}
+
+func (p *parser) parseType() string {
+	defer p.nest()()
+	var t string
+	if p.skip("[") {
+		t = "[" + p.parseType() + "]"
+		p.expect("]")
+	} else {
+		t = p.name()
+	}
+	if p.skip("!") {
+		t += "!"
+	}
+	return t
+}
+
+func (p *parser) parseSelectionSet() []*Selection {
+	defer p.nest()()
+	p.expect("{")
+	var selections []*Selection
+	for !p.skip("}") {
+		selections = append(selections, p.parseSelection())
+	}
+	if len(selections) == 0 {
+		p.fail("empty selection set")
+	}
+	return selections
+}
+
+func (p *parser) parseSelection() *Selection {
+	if p.skip("...") {
+		if p.token.kind == tokenName && !p.peekName("on") {
+			return &Selection{Kind: FragmentSpread, Name: p.name(), Directives: p.parseDirectives()}
+		}
+		selection := &Selection{Kind: InlineFragment}
+		if p.peekName("on") {
+			p.next()
+			selection.TypeCondition = p.name()
+		}
+		selection.Directives = p.parseDirectives()
+		selection.SelectionSet = p.parseSelectionSet()
+		return selection
+	}
+
+	selection := &Selection{Kind: FieldSelection, Name: p.name()}
+	if p.skip(":") {
+		selection.Alias, selection.Name = selection.Name, p.name()
+	}
+	selection.Arguments = p.parseArguments(false)
+	selection.Directives = p.parseDirectives()
+	if p.peek("{") {
+		selection.SelectionSet = p.parseSelectionSet()
+	}
+	return selection
+}
+
+func (p *parser) parseArguments(constant bool) map[string]interface{} {
+	arguments := map[string]interface{}{}
+	if !p.skip("(") {
+		return arguments
+	}
+	for !p.skip(")") {
+		name := p.name()
+		if _, found := arguments[name]; found {
+			p.fail("argument '%s' is given twice", name)
+		}
+		p.expect(":")
+		arguments[name] = p.parseValue(constant)
+	}
+	return arguments
+}
+
+func (p *parser) parseDirectives() []*Directive {
+	var directives []*Directive
+	for p.skip("@") {
+		directives = append(directives, &Directive{Name: p.name(), Arguments: p.parseArguments(false)})
+	}
+	return directives
+}
+
+func (p *parser) parseValue(constant bool) interface{} {
+	defer p.nest()()
+	t := p.token
+	switch {
+	case p.peek("$"):
+		if constant {
+			p.fail("variables are not allowed here")
+		}
+		p.next()
+		return Variable(p.name())
+	case p.peek("["):
+		p.next()
+		list := []interface{}{}
+		for !p.skip("]") {
+			list = append(list, p.parseValue(constant))
+		}
+		return list
+	case p.peek("{"):
+		p.next()
+		object := map[string]interface{}{}
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			object[name] = p.parseValue(constant)
+		}
+		return object
+	case t.kind == tokenInt:
+		p.next()
+		value, err := strconv.Atoi(t.value)
+		if err != nil {
+			p.fail("invalid int %s", t.value)
+		}
+		return value
+	case t.kind == tokenFloat:
+		p.next()
+		value, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			p.fail("invalid float %s", t.value)
+		}
+		return value
+	case t.kind == tokenString:
+		p.next()
+		return t.value
+	case t.kind == tokenName:
+		p.next()
+		switch t.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return t.value
+	}
+	p.fail("expected a value, got %s", p.describe())
+	return nil
+}
+
+// next reads the next token, skipping white space, commas and comments
+func (p *parser) next() {
+	for p.offset < len(p.source) {
+		c := p.source[p.offset]
+		if c == '#' {
+			for p.offset < len(p.source) && p.source[p.offset] != '\n' && p.source[p.offset] != '\r' {
+				p.offset++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.offset++
+	}
+
+	start := p.offset
+	p.token = token{offset: start}
+	if start >= len(p.source) {
+		return
+	}
+
+	c := p.source[start]
+	switch {
+	case strings.HasPrefix(p.source[start:], "..."):
+		p.token.kind, p.token.value = tokenPunctuator, "..."
+		p.offset += 3
+	case strings.ContainsRune("!$()=:@[]{}|", rune(c)):
+		p.token.kind, p.token.value = tokenPunctuator, string(c)
+		p.offset++
+	case c == '_' || isLetter(c):
+		for p.offset < len(p.source) && (p.source[p.offset] == '_' || isLetter(p.source[p.offset]) || isDigit(p.source[p.offset])) {
+			p.offset++
+		}
+		p.token.kind, p.token.value = tokenName, p.source[start:p.offset]
+	case c == '-' || isDigit(c):
+		p.lexNumber()
+	case c == '"':
+		p.lexString()
+	default:
+		p.fail("unexpected character %q", rune(c))
+	}
+}
+
+func (p *parser) lexNumber() {
+	start := p.offset
+	digits := func() int {
+		from := p.offset
+		for p.offset < len(p.source) && isDigit(p.source[p.offset]) {
+			p.offset++
+		}
+		return p.offset - from
+	}
+
+	p.token.kind = tokenInt
+	if p.source[p.offset] == '-' {
+		p.offset++
+	}
+	if digits() == 0 {
+		p.fail("invalid number")
+	}
+	if p.offset < len(p.source) && p.source[p.offset] == '.' {
+		p.offset++
+		p.token.kind = tokenFloat
+		if digits() == 0 {
+			p.fail("invalid number")
+		}
+	}
+	if p.offset < len(p.source) && (p.source[p.offset] == 'e' || p.source[p.offset] == 'E') {
+		p.offset++
+		p.token.kind = tokenFloat
+		if p.offset < len(p.source) && (p.source[p.offset] == '+' || p.source[p.offset] == '-') {
+			p.offset++
+		}
+		if digits() == 0 {
+			p.fail("invalid number")
+		}
+	}
+	p.token.value = p.source[start:p.offset]
+}
+
+func (p *parser) lexString() {
+	if strings.HasPrefix(p.source[p.offset:], `"""`) {
+		p.fail("block strings are not supported")
+	}
+	p.offset++
+
+	var value strings.Builder
+	for {
+		if p.offset >= len(p.source) || p.source[p.offset] == '\n' || p.source[p.offset] == '\r' {
+			p.fail("unterminated string")
+		}
+		c := p.source[p.offset]
+		p.offset++
+		if c == '"' {
+			break
+		}
+		if c != '\\' {
+			value.WriteByte(c)
+			continue
+		}
+
+		if p.offset >= len(p.source) {
+			p.fail("unterminated string")
+		}
+		escape := p.source[p.offset]
+		p.offset++
+		switch escape {
+		case '"', '\\', '/':
+			value.WriteByte(escape)
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'u':
+			if p.offset+4 > len(p.source) {
+				p.fail("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(p.source[p.offset:p.offset+4], 16, 32)
+			if err != nil {
+				p.fail("invalid unicode escape")
+			}
+			value.WriteRune(rune(code))
+			p.offset += 4
+		default:
+			p.fail("invalid escape \\%c", escape)
+		}
+	}
+	p.token.kind, p.token.value = tokenString, value.String()
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
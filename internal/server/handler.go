@@ -1,10 +1,15 @@
 package server
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"github.com/b4bay/aspm/internal/shared"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -14,92 +19,248 @@ type RequestBody struct {
 
 func CollectHandler(w http.ResponseWriter, r *http.Request) {
 	var body shared.CollectMessageBody
-	err := json.NewDecoder(r.Body).Decode(&body)
-	if err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	signer, ok := decodeSigned(w, r, shared.CollectPayloadType, &body)
+	if !ok {
 		return
 	}
+	var err error
 
-	for _, report := range body.Reports {
-		DB.Transaction(func(tx *gorm.DB) error {
-			// Ensure Product exists
-			var artefact Product
-			if err := tx.FirstOrCreate(&artefact, Product{
-				ProductID: body.Artefact.Id,
-			}).Error; err != nil {
-				http.Error(w, "Failed to create or find product", http.StatusInternalServerError)
-				return err
-			}
+	body.Environment = EnvironmentFilter.Apply(body.Environment)
 
-			var project = GetProjectFromEnvironment(body.Environment)
-			var worker = GetWorkerFromEnvironment(body.Environment)
-			var author string
-			if body.Artefact.Author != "" {
-				author = body.Artefact.Author
-			} else {
-				author = GetAuthorFromEnvironment(body.Environment)
-			}
+	if err = NormalizeProductMessage(&body.Artefact); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-			var needToUpdate = false
-			// Check and update empty fields in Product
-			if artefact.Name == "" && body.Artefact.Name != "" {
-				artefact.Name = body.Artefact.Name
-				needToUpdate = true
-			}
-			if artefact.Type == "" && body.Artefact.Type != "" {
-				artefact.Type = body.Artefact.Type
-				needToUpdate = true
-			}
-			if artefact.Project == "" && project != "" {
-				artefact.Project = project
-				needToUpdate = true
-			}
-			if artefact.Author == "" && author != "" {
-				artefact.Author = author
-				needToUpdate = true
-			}
-			if artefact.Worker == "" && worker != "" {
-				artefact.Worker = worker
-				needToUpdate = true
-			}
+	// Older clients do not send the key, derive it the same way they would
+	if body.IdempotencyKey == "" {
+		body.IdempotencyKey = shared.CollectIdempotencyKey(body.Artefact.Id, body.Reports)
+	}
 
-			// Save updated product if necessary
-			if needToUpdate {
-				if err := tx.Save(&artefact).Error; err != nil {
-					http.Error(w, "Failed to update product", http.StatusInternalServerError)
-					return err
-				}
+	// Repeated upload is answered with the original receipt and not processed again
+	var receipt Receipt
+	err = DB.Where("idempotency_key = ?", body.IdempotencyKey).First(&receipt).Error
+	if err == nil {
+		if err := SignReceipt(DB, &receipt, signer); err != nil {
+			http.Error(w, "Failed to sign receipt", http.StatusInternalServerError)
+			return
+		}
+		replayReceipt(w, receipt)
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Failed to check receipt", http.StatusInternalServerError)
+		return
+	}
+
+	// The artefact may be known by another digest already
+	if body.Artefact.Id, err = ResolveProductID(DB, body.Artefact.Id, body.Artefact.Aliases()...); err != nil {
+		http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+		return
+	}
+
+	if len(body.Reports) == 0 {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Data collected successfully"))
+		return
+	}
+
+	// All the reports are saved with the receipt or none is, so a retry after a failure processes them all again.
+	// The receipt is taken first, a concurrent upload with the same key finds it taken and replays it.
+	receipt = Receipt{
+		IdempotencyKey: body.IdempotencyKey,
+		ProductID:      body.Artefact.Id,
+		Engagements:    len(body.Reports),
+		Signer:         signer,
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&receipt)
+		if result.Error != nil {
+			http.Error(w, "Failed to create receipt", http.StatusInternalServerError)
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errReceiptTaken
+		}
+
+		// Ensure Product exists, its files and aliases are saved once for all the reports
+		var artefact Product
+		if err := tx.FirstOrCreate(&artefact, Product{
+			ProductID: body.Artefact.Id,
+		}).Error; err != nil {
+			http.Error(w, "Failed to create or find product", http.StatusInternalServerError)
+			return err
+		}
+
+		var ci = GetCIContext(body.CI, body.Environment)
+		var project = ci.Project
+		var ref = ci.Ref
+		var worker = ci.Worker
+		var author string
+		if body.Artefact.Author != "" {
+			author = body.Artefact.Author
+		} else {
+			author = ci.Author
+		}
+
+		var needToUpdate = false
+		// Check and update empty fields in Product
+		if artefact.Name == "" && body.Artefact.Name != "" {
+			artefact.Name = body.Artefact.Name
+			needToUpdate = true
+		}
+		if artefact.Type == "" && body.Artefact.Type != "" {
+			artefact.Type = body.Artefact.Type
+			needToUpdate = true
+		}
+		if !artefact.Dirty && body.Artefact.Dirty {
+			artefact.Dirty = true
+			needToUpdate = true
+		}
+		if artefact.BuildID == "" && body.Artefact.BuildID != "" {
+			artefact.BuildID = body.Artefact.BuildID
+			needToUpdate = true
+		}
+		if artefact.SetIdentity(body.Artefact) {
+			needToUpdate = true
+		}
+		if artefact.SetPipeline(ci) {
+			needToUpdate = true
+		}
+		if artefact.Project == "" && project != "" {
+			artefact.Project = project
+			needToUpdate = true
+		}
+		if artefact.Ref == "" && ref != "" {
+			artefact.Ref = ref
+			needToUpdate = true
+		}
+		if artefact.Author == "" && author != "" {
+			artefact.Author = author
+			needToUpdate = true
+		}
+		if artefact.Worker == "" && worker != "" {
+			artefact.Worker = worker
+			needToUpdate = true
+		}
+
+		// Save updated product if necessary
+		if needToUpdate {
+			if err := tx.Save(&artefact).Error; err != nil {
+				http.Error(w, "Failed to update product", http.StatusInternalServerError)
+				return err
 			}
+		}
 
+		if err := SaveProductFiles(tx, artefact.ProductID, body.Artefact.Files); err != nil {
+			http.Error(w, "Failed to save product files", http.StatusInternalServerError)
+			return err
+		}
+
+		if err := SaveProductAliases(tx, artefact.ProductID, body.Artefact.Aliases()); err != nil {
+			http.Error(w, "Failed to save product aliases", http.StatusInternalServerError)
+			return err
+		}
+
+		for _, report := range body.Reports {
 			// Save Engagement
 			engagement := Engagement{
 				ProductID: artefact.ProductID,
 				RawReport: report,
+				Signer:    signer,
+				ReceiptID: receipt.ID,
 			}
 
-			if err = engagement.UpdateTool(); err != nil {
+			if err := engagement.UpdateTool(); err != nil {
 				engagement.Tool = "unknown"
 			}
 
 			if result := tx.Create(&engagement); result.Error != nil {
 				http.Error(w, "Failed to create engagement", http.StatusInternalServerError)
-				return err
+				return result.Error
 			}
 
-			if err = engagement.Process(tx); err != nil {
+			if err := engagement.Process(tx); err != nil {
 				http.Error(w, "Failed to process engagement", http.StatusInternalServerError)
 				return err
 			}
+		}
+		return nil
+	})
+	if errors.Is(err, errReceiptTaken) {
+		if err = DB.Where("idempotency_key = ?", body.IdempotencyKey).First(&receipt).Error; err != nil {
+			http.Error(w, "Failed to check receipt", http.StatusInternalServerError)
+			return
+		}
+		if err := SignReceipt(DB, &receipt, signer); err != nil {
+			http.Error(w, "Failed to sign receipt", http.StatusInternalServerError)
+			return
+		}
+		replayReceipt(w, receipt)
+		return
+	}
+	if err != nil {
+		return
+	}
+	writeReceiptHeaders(w, receipt)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Data collected successfully"))
+}
 
-			return nil
-		})
+// decodeSigned reads the body of an upload, sent as is or signed in an envelope of the payload type, and returns its signer.
+// It answers the request itself when the body is not valid, or when the signature is not valid or missing while required.
+func decodeSigned(w http.ResponseWriter, r *http.Request, payloadType string, body interface{}) (string, bool) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil || !json.Valid(content) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return "", false
+	}
+	payload, signer, err := OpenPayload(DB, content, payloadType)
+	if !acceptSigner(w, signer, err) {
+		return "", false
+	}
+	if err := json.Unmarshal(payload, body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return "", false
+	}
+	return signer, true
+}
 
+// acceptSigner answers the request itself when the signature is not valid or missing while required
+func acceptSigner(w http.ResponseWriter, signer string, err error) bool {
+	if errors.Is(err, ErrUnknownSigningKey) || errors.Is(err, shared.ErrInvalidSignature) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	} else if err != nil {
+		http.Error(w, "Failed to verify signature", http.StatusInternalServerError)
+		return false
 	}
 
+	if signer == "" && RequireSignature {
+		http.Error(w, "Signed payload required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// errReceiptTaken stops the upload when the idempotency key has been taken by another one meanwhile
+var errReceiptTaken = errors.New("receipt taken")
+
+// replayReceipt answers a repeated upload with the receipt of the original one
+func replayReceipt(w http.ResponseWriter, receipt Receipt) {
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeReceiptHeaders(w, receipt)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Data collected successfully"))
 }
 
+func writeReceiptHeaders(w http.ResponseWriter, receipt Receipt) {
+	w.Header().Set("Idempotency-Key", receipt.IdempotencyKey)
+	w.Header().Set("X-Receipt-Id", strconv.FormatUint(uint64(receipt.ID), 10))
+	w.Header().Set("X-Receipt-Created-At", receipt.CreatedAt.UTC().Format(time.RFC3339))
+}
+
 func OriginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -107,26 +268,55 @@ func OriginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var body shared.OriginMessageBody
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	signer, ok := decodeSigned(w, r, shared.OriginPayloadType, &body)
+	if !ok {
 		return
 	}
 
+	body.Environment = EnvironmentFilter.Apply(body.Environment)
+
+	if err := NormalizeProductMessage(&body.Product); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range body.Origins {
+		if err := NormalizeProductMessage(&body.Origins[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	if body.ProductionMethod == "" {
 		body.ProductionMethod = shared.ProductionMethodDefault
 	}
 
-	var project = GetProjectFromEnvironment(body.Environment)
-	var worker = GetWorkerFromEnvironment(body.Environment)
+	if err := saveOrigin(DB, w, body, signer); err != nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Links created successfully"))
+}
+
+// saveOrigin creates the product, its origins and the links between them, answering the request itself on failure
+func saveOrigin(tx *gorm.DB, w http.ResponseWriter, body shared.OriginMessageBody, signer string) error {
+	var ci = GetCIContext(body.CI, body.Environment)
+	var project = ci.Project
+	var ref = ci.Ref
+	var worker = ci.Worker
 	var author string
 	if body.Product.Author != "" {
 		author = body.Product.Author
 	} else {
-		author = GetAuthorFromEnvironment(body.Environment)
+		author = ci.Author
 	}
 
-	DB.Transaction(func(tx *gorm.DB) error {
-		// Ensure Product exists
+	return tx.Transaction(func(tx *gorm.DB) (err error) {
+		// Ensure Product exists, it may be known by another digest already
+		if body.Product.Id, err = ResolveProductID(tx, body.Product.Id, body.Product.Aliases()...); err != nil {
+			http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+			return err
+		}
 		var product Product
 		if err := tx.FirstOrCreate(&product, Product{
 			ProductID: body.Product.Id,
@@ -149,10 +339,28 @@ func OriginHandler(w http.ResponseWriter, r *http.Request) {
 			product.Type = body.Product.Type
 			needToUpdate = true
 		}
+		if !product.Dirty && body.Product.Dirty {
+			product.Dirty = true
+			needToUpdate = true
+		}
+		if product.BuildID == "" && body.Product.BuildID != "" {
+			product.BuildID = body.Product.BuildID
+			needToUpdate = true
+		}
+		if product.SetIdentity(body.Product) {
+			needToUpdate = true
+		}
+		if product.SetPipeline(ci) {
+			needToUpdate = true
+		}
 		if product.Project == "" && project != "" {
 			product.Project = project
 			needToUpdate = true
 		}
+		if product.Ref == "" && ref != "" {
+			product.Ref = ref
+			needToUpdate = true
+		}
 		if product.Author == "" && author != "" {
 			product.Author = author
 			needToUpdate = true
@@ -170,8 +378,22 @@ func OriginHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if err := SaveProductFiles(tx, product.ProductID, body.Product.Files); err != nil {
+			http.Error(w, "Failed to save product files", http.StatusInternalServerError)
+			return err
+		}
+
+		if err := SaveProductAliases(tx, product.ProductID, body.Product.Aliases()); err != nil {
+			http.Error(w, "Failed to save product aliases", http.StatusInternalServerError)
+			return err
+		}
+
 		// Process OriginIds and create Links
 		for _, o := range body.Origins {
+			if o.Id, err = ResolveProductID(tx, o.Id, o.Aliases()...); err != nil {
+				http.Error(w, "Failed to resolve origin", http.StatusInternalServerError)
+				return err
+			}
 			var origin Product
 			if err := tx.FirstOrCreate(&origin, Product{
 				ProductID: o.Id,
@@ -194,6 +416,17 @@ func OriginHandler(w http.ResponseWriter, r *http.Request) {
 				origin.Type = o.Type
 				needToUpdate = true
 			}
+			if !origin.Dirty && o.Dirty {
+				origin.Dirty = true
+				needToUpdate = true
+			}
+			if origin.BuildID == "" && o.BuildID != "" {
+				origin.BuildID = o.BuildID
+				needToUpdate = true
+			}
+			if origin.SetIdentity(o) {
+				needToUpdate = true
+			}
 			if origin.Author == "" && o.Author != "" {
 				origin.Author = o.Author
 				needToUpdate = true
@@ -207,6 +440,16 @@ func OriginHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
+			if err := SaveProductFiles(tx, origin.ProductID, o.Files); err != nil {
+				http.Error(w, "Failed to save origin files", http.StatusInternalServerError)
+				return err
+			}
+
+			if err := SaveProductAliases(tx, origin.ProductID, o.Aliases()); err != nil {
+				http.Error(w, "Failed to save origin aliases", http.StatusInternalServerError)
+				return err
+			}
+
 			var link = Link{}
 			if err := tx.FirstOrCreate(&link, Link{
 				ProductID: product.ProductID,
@@ -223,6 +466,10 @@ func OriginHandler(w http.ResponseWriter, r *http.Request) {
 				link.CreatedAt = time.Now()
 				needToUpdate = true
 			}
+			if link.Signer == "" && signer != "" {
+				link.Signer = signer
+				needToUpdate = true
+			}
 
 			// Save updated link if necessary
 			if needToUpdate {
@@ -236,17 +483,299 @@ func OriginHandler(w http.ResponseWriter, r *http.Request) {
 
 		return nil
 	})
+}
+
+// AttestationHandler creates links from SLSA provenance, in a DSSE envelope or a bare statement,
+// as an alternative to origin uploads for pipelines which make provenance anyway
+func AttestationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var envelope shared.Envelope
+	var statement shared.Statement
+	var signer string
+	var err error
+	if json.Unmarshal(raw, &envelope) == nil && envelope.PayloadType != "" {
+		if statement, err = envelope.Statement(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		signer, err = VerifyEnvelopeSigner(DB, envelope)
+	} else if err = json.Unmarshal(raw, &statement); err != nil {
+		http.Error(w, "Invalid statement", http.StatusBadRequest)
+		return
+	}
+	if !acceptSigner(w, signer, err) {
+		return
+	}
+
+	bodies, err := statement.OriginMessages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range bodies {
+		if err := NormalizeProductMessage(&bodies[i].Product); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for j := range bodies[i].Origins {
+			if err := NormalizeProductMessage(&bodies[i].Origins[j]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// Subjects of the statement are saved all or none
+	if err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, body := range bodies {
+			if err := saveOrigin(tx, w, body, signer); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Links created successfully"))
+	w.Write([]byte("Attestation accepted successfully"))
+}
+
+// MergeHandler merges two products found to be the same artefact, see MergeProducts
+func MergeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorizeAdmin(w, r) {
+		return
+	}
+
+	var body shared.MergeMessageBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var from, into string
+	var err error
+	if from, err = ResolveProductID(DB, body.From); err == nil {
+		into, err = ResolveProductID(DB, body.Into)
+	}
+	if err != nil {
+		http.Error(w, "Failed to find products", http.StatusInternalServerError)
+		return
+	}
+
+	if err = DB.Transaction(func(tx *gorm.DB) error {
+		return MergeProducts(tx, from, into)
+	}); errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Products merged successfully"))
+}
+
+// KeyHandler registers the public key of a signer, see RegisterSigningKey
+func KeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorizeAdmin(w, r) {
+		return
+	}
+
+	var body shared.KeyMessageBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	key, err := RegisterSigningKey(DB, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("X-Key-Id", key.KeyID)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Key registered successfully"))
 }
 
+// authorizeAdmin answers the request itself unless it carries the administrative key
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if AdminKey == "" {
+		http.Error(w, "Administrative operations are disabled", http.StatusForbidden)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+AdminKey)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// authorizeTriage answers the request itself unless it carries the triage or the admin key
+func authorizeTriage(w http.ResponseWriter, r *http.Request) bool {
+	if TriageKey == "" && AdminKey == "" {
+		http.Error(w, "Triage is disabled", http.StatusForbidden)
+		return false
+	}
+	given := []byte(r.Header.Get("Authorization"))
+	for _, key := range []string{TriageKey, AdminKey} {
+		if key != "" && subtle.ConstantTimeCompare(given, []byte("Bearer "+key)) == 1 {
+			return true
+		}
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// TriageHandler records a decision on findings, see Triage. Decisions make the gate pass, they take the triage key.
+func TriageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeTriage(w, r) {
+		return
+	}
+
+	var body shared.TriageMessageBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if !shared.IsValidStatusKind(body.Status) {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+	if !shared.IsValidStatusPropagation(body.Propagation) {
+		http.Error(w, "Invalid propagation", http.StatusBadRequest)
+		return
+	}
+	if body.FindingID == 0 && (body.ProductID == "" || body.Rule == "" || body.Path == "") {
+		http.Error(w, "Finding id or product, rule and path required", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if body.ProductID != "" {
+		if body.ProductID, err = ResolveProductID(DB, body.ProductID); err != nil {
+			http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var count int
+	if err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		count, err = Triage(tx, body)
+		return err
+	}); errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Finding not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to triage findings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Triaged-Findings", strconv.Itoa(count))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Findings triaged successfully"))
+}
+
+// GWHandler compares the findings of the product with the ones of its baseline, e.g. ?product=<id>&baseline=main,
+// so the gate breaks on introduced findings only. Without a product it answers it is functional.
 func GWHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("GW endpoint is functional"))
+	id := r.URL.Query().Get("product")
+	if id == "" {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("GW endpoint is functional"))
+		return
+	}
+
+	productID, err := ResolveProductID(DB, id)
+	if err != nil {
+		http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+		return
+	}
+	var product Product
+	if err = DB.Where("product_id = ?", productID).Take(&product).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch product", http.StatusInternalServerError)
+		return
+	}
+
+	var baseline Product
+	if b := r.URL.Query().Get("baseline"); b != "" {
+		if baseline, err = ResolveBaseline(DB, product, b); errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Baseline not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to resolve baseline", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	comparison, err := CompareFindings(DB, product.ProductID, baseline.ProductID)
+	if err != nil {
+		http.Error(w, "Failed to compare findings", http.StatusInternalServerError)
+		return
+	}
+
+	evidence, err := GateEvidence(DB, product.ProductID)
+	if err != nil {
+		http.Error(w, "Failed to fetch engagements", http.StatusInternalServerError)
+		return
+	}
+
+	response := GateResponse{ProductID: product.ProductID, BaselineID: baseline.ProductID, Evidence: []EngagementResponse{}}
+	for _, engagement := range evidence {
+		response.Evidence = append(response.Evidence, newEngagementResponse(engagement))
+	}
+	for _, part := range []struct {
+		vulnerabilities []Vulnerability
+		responses       *[]VulnerabilityResponse
+	}{
+		{comparison.Introduced, &response.Introduced},
+		{comparison.Fixed, &response.Fixed},
+		{comparison.Unchanged, &response.Unchanged},
+	} {
+		if *part.responses, err = newVulnerabilityResponses(DB, part.vulnerabilities); err != nil {
+			http.Error(w, "Failed to fetch statuses", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode comparison to JSON", http.StatusInternalServerError)
+		return
+	}
 }
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"gorm.io/gorm"
+)
+
+// MaxLineageDepth bounds the walk, lineage of images with hundreds of components gets big quickly
+const MaxLineageDepth = 10
+
+// Lineage walks the links of the product, up to its origins and down to the products made of it.
+// Nodes deeper than the depth are left out and their parent marked truncated. A product reached again by another link,
+// e.g. a library shared by several binaries, is walked once and listed as repeated elsewhere.
+func Lineage(tx *gorm.DB, productID string, up bool, down bool, depth int) (LineageNodeResponse, error) {
+	if depth <= 0 || depth > MaxLineageDepth {
+		depth = MaxLineageDepth
+	}
+
+	var product Product
+	if err := tx.Where("product_id = ?", productID).Take(&product).Error; err != nil {
+		return LineageNodeResponse{}, err
+	}
+
+	root := LineageNodeResponse{ProductID: product.ProductID, Name: product.Name, Type: product.Type}
+	if up {
+		if err := walkLineage(tx, &root, false, depth); err != nil {
+			return root, err
+		}
+	}
+	if down {
+		if err := walkLineage(tx, &root, true, depth); err != nil {
+			return root, err
+		}
+	}
+	return root, countOpenFindings(tx, &root)
+}
+
+// walkLineage fetches the links a level at a time, like BlastRadius, and builds the tree from them.
+// The link a product is first reached by is the one its lineage is shown under.
+func walkLineage(tx *gorm.DB, root *LineageNodeResponse, down bool, depth int) error {
+	children := map[string][]Link{}
+	owner := map[string]uint{root.ProductID: 0}
+	truncated := map[string]bool{}
+
+	frontier := []string{root.ProductID}
+	for level := 0; len(frontier) > 0; level++ {
+		var links []Link
+		query := tx.Preload("Origin").Where("product_id IN ?", frontier)
+		if down {
+			query = tx.Preload("Product").Where("origin_id IN ?", frontier)
+		}
+		if err := query.Order("id").Find(&links).Error; err != nil {
+			return err
+		}
+
+		var next []string
+		for _, link := range links {
+			parent, child := link.ProductID, link.OriginID
+			if down {
+				parent, child = link.OriginID, link.ProductID
+			}
+			if level == depth {
+				truncated[parent] = true
+				continue
+			}
+			children[parent] = append(children[parent], link)
+			if _, ok := owner[child]; !ok {
+				owner[child] = link.ID
+				next = append(next, child)
+			}
+		}
+		frontier = next
+	}
+
+	var build func(node *LineageNodeResponse)
+	build = func(node *LineageNodeResponse) {
+		node.Truncated = node.Truncated || truncated[node.ProductID]
+		for _, link := range children[node.ProductID] {
+			next, id := link.Origin, link.OriginID
+			if down {
+				next, id = link.Product, link.ProductID
+			}
+			child := LineageNodeResponse{ProductID: id, Name: next.Name, Type: next.Type, Method: link.Type}
+			if owner[child.ProductID] == link.ID {
+				build(&child)
+			} else {
+				child.Repeated = true
+			}
+
+			if down {
+				node.Products = append(node.Products, child)
+			} else {
+				node.Origins = append(node.Origins, child)
+			}
+		}
+	}
+	build(root)
+	return nil
+}
+
+// countOpenFindings fills the open findings of every node of the lineage, with one query for all of them
+func countOpenFindings(tx *gorm.DB, root *LineageNodeResponse) error {
+	var productIDs []string
+	seen := map[string]bool{}
+	var collect func(node *LineageNodeResponse)
+	collect = func(node *LineageNodeResponse) {
+		if !seen[node.ProductID] {
+			seen[node.ProductID] = true
+			productIDs = append(productIDs, node.ProductID)
+		}
+		for i := range node.Origins {
+			collect(&node.Origins[i])
+		}
+		for i := range node.Products {
+			collect(&node.Products[i])
+		}
+	}
+	collect(root)
+
+	var rows []struct {
+		ProductID string
+		Count     int64
+	}
+	if err := tx.Model(&Vulnerability{}).Select("product_id, COUNT(*) AS count").
+		Where("product_id IN ? AND id NOT IN (?)", productIDs, tx.Model(&Status{}).Select("vulnerability_id")).
+		Group("product_id").Scan(&rows).Error; err != nil {
+		return err
+	}
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.ProductID] = row.Count
+	}
+
+	var fill func(node *LineageNodeResponse)
+	fill = func(node *LineageNodeResponse) {
+		node.OpenFindings = counts[node.ProductID]
+		for i := range node.Origins {
+			fill(&node.Origins[i])
+		}
+		for i := range node.Products {
+			fill(&node.Products[i])
+		}
+	}
+	fill(root)
+	return nil
+}
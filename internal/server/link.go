@@ -10,7 +10,33 @@ type Link struct {
 	ProductID string `gorm:"index;not null"`
 	OriginID  string `gorm:"index;not null"`
 	Type      shared.ProductionMethod
+	Signer    string // See Engagement.Signer
 	// Associations
 	Product Product `gorm:"constraint:OnDelete:CASCADE;foreignKey:ProductID;references:ProductID"`
 	Origin  Product `gorm:"constraint:OnDelete:CASCADE;foreignKey:OriginID;references:ProductID"`
 }
+
+// StripTwinIDs returns the product with every product linked to it by strip links in either direction.
+// They are builds of the same code, so findings on any of them apply to all.
+func StripTwinIDs(tx *gorm.DB, productID string) ([]string, error) {
+	seen := map[string]bool{productID: true}
+	ids := []string{productID}
+	for queue := []string{productID}; len(queue) > 0; queue = queue[1:] {
+		var links []Link
+		if err := tx.Where("type = ? AND (product_id = ? OR origin_id = ?)", shared.ProductionMethodStrip, queue[0], queue[0]).
+			Find(&links).Error; err != nil {
+			return nil, err
+		}
+		for _, link := range links {
+			for _, id := range []string{link.ProductID, link.OriginID} {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+					queue = append(queue, id)
+				}
+			}
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 100 // Page of a cursor given without a limit
+	MaxPageLimit     = 1000
+)
+
+// Page is the part of a list asked for with ?limit=&cursor=&sort=, e.g. sort=-created_at for the newest first.
+// The cursor is the id of the last row of the previous page, so pages stay stable while rows are added.
+// Lists are paginated only when a limit or a cursor is asked for, clients made before pagination get every row.
+type Page struct {
+	Limit  int // Zero for every row
+	Column string
+	Desc   bool
+	After  uint
+}
+
+// ParsePage reads the page of the request, sorting is allowed by the columns only, in the order of ids by default
+func ParsePage(r *http.Request, columns ...string) (Page, error) {
+	page := Page{Column: "id"}
+	query := r.URL.Query()
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return page, fmt.Errorf("invalid limit '%s'", value)
+		}
+		page.Limit = min(limit, MaxPageLimit)
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		column, desc := strings.CutPrefix(sort, "-")
+		if column != "id" && !slices.Contains(columns, column) {
+			return page, fmt.Errorf("invalid sort '%s'", sort)
+		}
+		page.Column, page.Desc = column, desc
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		// The cursor is bound to the sort it was made for
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		sort, id, found := strings.Cut(string(decoded), ":")
+		after, parseErr := strconv.ParseUint(id, 10, 64)
+		if err != nil || !found || parseErr != nil || sort != page.sort() {
+			return page, fmt.Errorf("invalid cursor '%s'", cursor)
+		}
+		page.After = uint(after)
+		if page.Limit == 0 {
+			page.Limit = DefaultPageLimit
+		}
+	}
+
+	return page, nil
+}
+
+func (p Page) sort() string {
+	if p.Desc {
+		return "-" + p.Column
+	}
+	return p.Column
+}
+
+// Apply orders and limits the query on the table, one row more than the limit is fetched to know whether another page follows
+func (p Page) Apply(tx *gorm.DB, table string) *gorm.DB {
+	order, compare := "ASC", ">"
+	if p.Desc {
+		order, compare = "DESC", "<"
+	}
+
+	column := table + "." + p.Column
+	id := table + ".id"
+	if p.After != 0 {
+		if p.Column == "id" {
+			tx = tx.Where(fmt.Sprintf("%s %s ?", id, compare), p.After)
+		} else {
+			// Rows sharing the value of the last one are told apart by id
+			last := fmt.Sprintf("(SELECT %s FROM %s WHERE id = ?)", p.Column, table)
+			tx = tx.Where(fmt.Sprintf("(%s %s %s OR (%s = %s AND %s %s ?))", column, compare, last, column, last, id, compare),
+				p.After, p.After, p.After)
+		}
+	}
+
+	if p.Column != "id" {
+		tx = tx.Order(column + " " + order)
+	}
+	tx = tx.Order(id + " " + order)
+	if p.Limit == 0 {
+		return tx
+	}
+	return tx.Limit(p.Limit + 1)
+}
+
+// Rows drops the row fetched to look ahead and announces the cursor of the next page, if any,
+// in the X-Next-Cursor header and a Link header with rel="next"
+func Rows[T any](w http.ResponseWriter, r *http.Request, p Page, rows []T, id func(T) uint) []T {
+	if p.Limit == 0 || len(rows) <= p.Limit {
+		return rows
+	}
+	rows = rows[:p.Limit]
+
+	cursor := base64.RawURLEncoding.EncodeToString([]byte(p.sort() + ":" + strconv.FormatUint(uint64(id(rows[len(rows)-1])), 10)))
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("X-Next-Cursor", cursor)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+	return rows
+}
+
+// CreatedBetween keeps the rows of the table created from ?since= on and before ?until=, as RFC 3339 times or dates
+func CreatedBetween(tx *gorm.DB, r *http.Request, table string) (*gorm.DB, error) {
+	for param, condition := range map[string]string{"since": " >= ?", "until": " < ?"} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if at, err = time.Parse(time.DateOnly, value); err != nil {
+				return tx, fmt.Errorf("invalid %s '%s'", param, value)
+			}
+		}
+		// Times are stored in local time, compared as they are
+		tx = tx.Where(table+".created_at"+condition, at.Local())
+	}
+	return tx, nil
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"fmt"
 	"github.com/b4bay/aspm/internal/shared"
 	"gorm.io/gorm"
+	"strings"
 )
 
 type Product struct {
@@ -11,6 +13,102 @@ type Product struct {
 	Name      string
 	Type      shared.ArtefactType
 	Project   string
+	Ref       string `gorm:"index"` // Branch or tag the product was first seen built from, see ResolveBaseline
 	Author    string
 	Worker    string
+	Provider  string // CI system, revision and pipeline of the build the product was first seen in
+	Revision  string
+	Pipeline  string
+	Dirty     bool   // Built from a source tree with uncommitted changes
+	BuildID   string `gorm:"index"` // ELF build-id, shared by a stripped binary and its debug build
+	Purl      string `gorm:"index"` // Normalized package URL
+	PurlBase  string `gorm:"index"` // Package URL without qualifiers and subpath
+	CPE       string `gorm:"index"`
+	// Associations
+	Aliases []ProductAlias `gorm:"foreignKey:ProductID;references:ProductID"`
+}
+
+// NormalizeProductMessage validates the package identifiers of a message and brings them to their canonical form.
+// Product ids which are package URLs are the purl of the product.
+func NormalizeProductMessage(m *shared.ProductMessage) error {
+	idIsPurl := strings.HasPrefix(m.Id, "pkg:")
+	if m.Purl == "" && idIsPurl {
+		m.Purl = m.Id
+	}
+
+	if m.Purl != "" {
+		purl, err := shared.ParsePurl(m.Purl)
+		if err != nil {
+			return fmt.Errorf("invalid purl '%s': %w", m.Purl, err)
+		}
+		if idIsPurl && m.Id == m.Purl {
+			m.Id = purl.String()
+		}
+		m.Purl = purl.String()
+	}
+
+	if m.CPE != "" {
+		cpe, err := shared.NormalizeCPE(m.CPE)
+		if err != nil {
+			return fmt.Errorf("invalid CPE '%s': %w", m.CPE, err)
+		}
+		m.CPE = cpe
+	}
+
+	return nil
+}
+
+// SetIdentity fills the package identifiers the product has no value for yet, it tells whether anything changed
+func (p *Product) SetIdentity(m shared.ProductMessage) bool {
+	var changed bool
+	if p.Purl == "" && m.Purl != "" {
+		p.Purl = m.Purl
+		p.PurlBase = PurlBase(m.Purl)
+		changed = true
+	}
+	if p.CPE == "" && m.CPE != "" {
+		p.CPE = m.CPE
+		changed = true
+	}
+	return changed
+}
+
+// SetPipeline fills the CI details the product has no value for yet, it tells whether anything changed
+func (p *Product) SetPipeline(ci shared.CIContext) bool {
+	var changed bool
+	if p.Provider == "" && ci.Provider != "" {
+		p.Provider = ci.Provider
+		changed = true
+	}
+	if p.Revision == "" && ci.Revision != "" {
+		p.Revision = ci.Revision
+		changed = true
+	}
+	if p.Pipeline == "" && ci.URL != "" {
+		p.Pipeline = ci.URL
+		changed = true
+	}
+	return changed
+}
+
+// CIContext is the context of the build the product was first seen in
+func (p Product) CIContext() shared.CIContext {
+	return shared.CIContext{
+		Provider: p.Provider,
+		Project:  p.Project,
+		Ref:      p.Ref,
+		Revision: p.Revision,
+		Author:   p.Author,
+		Worker:   p.Worker,
+		URL:      p.Pipeline,
+	}
+}
+
+// PurlBase strips qualifiers and subpath of a package URL, invalid ones give an empty string
+func PurlBase(purl string) string {
+	parsed, err := shared.ParsePurl(purl)
+	if err != nil {
+		return ""
+	}
+	return parsed.Base()
 }
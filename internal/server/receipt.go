@@ -0,0 +1,30 @@
+package server
+
+import (
+	"gorm.io/gorm"
+)
+
+// Receipt records an accepted upload, so a repeated upload with the same idempotency key is not processed twice
+type Receipt struct {
+	gorm.Model
+	IdempotencyKey string `gorm:"uniqueIndex;not null"`
+	ProductID      string `gorm:"index;not null"`
+	Engagements    int
+	Signer         string // See Engagement.Signer
+	// Associations
+	Product Product `gorm:"constraint:OnDelete:CASCADE;foreignKey:ProductID;references:ProductID"`
+}
+
+// SignReceipt records the signer of a repeated upload on an unsigned one and its engagements.
+// The same content sent unsigned first and signed later is evidence signed by the key.
+func SignReceipt(tx *gorm.DB, receipt *Receipt, signer string) error {
+	if signer == "" || receipt.Signer != "" {
+		return nil
+	}
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(receipt).Update("signer", signer).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Engagement{}).Where("receipt_id = ? AND signer = ''", receipt.ID).Update("signer", signer).Error
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 )
 
@@ -296,6 +297,31 @@ type Result struct {
 	Properties     PropertyBag                  `json:"properties,omitempty"`
 	Locations      []Location                   `json:"locations,omitempty"` // location where result was detected
 	// Attachments    interface{}                  `json:"attachments,omitempty"`
+	// Added
+	Fingerprints        map[string]string `json:"fingerprints,omitempty"`        // Stable identity of the result computed by the tool
+	PartialFingerprints map[string]string `json:"partialFingerprints,omitempty"` // Contributions to it, e.g. a hash of the line
+}
+
+// Fingerprint is the identity the tool gives the result, its fingerprints or else its partial ones, empty if it gives none
+func (r *Result) Fingerprint() string {
+	fingerprints := r.Fingerprints
+	if len(fingerprints) == 0 {
+		fingerprints = r.PartialFingerprints
+	}
+	var parts []string
+	for name, value := range fingerprints {
+		parts = append(parts, name+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\x00")
+}
+
+// LocationUri is the artifact uri of the first location, empty if there is none
+func (r *Result) LocationUri() string {
+	if len(r.Locations) == 0 {
+		return ""
+	}
+	return r.Locations[0].PhysicalLocation.ArtifactLocation.Uri
 }
 
 func (r *Result) LocationHash() string {
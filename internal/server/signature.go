@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/b4bay/aspm/internal/shared"
+	"gorm.io/gorm"
+)
+
+// SigningKey is a registered public key, payloads signed with it are recorded as coming from its signer
+type SigningKey struct {
+	gorm.Model
+	KeyID     string `gorm:"uniqueIndex;not null"`
+	Signer    string `gorm:"not null"`
+	PublicKey string `gorm:"not null"` // Base64 encoded raw key
+}
+
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// RegisterSigningKey adds the public key of the signer, registering a known key again renames its signer
+func RegisterSigningKey(tx *gorm.DB, body shared.KeyMessageBody) (SigningKey, error) {
+	var key SigningKey
+	if body.Name == "" {
+		return key, fmt.Errorf("signer name required")
+	}
+	public, err := shared.ParsePublicKey(body.PublicKey)
+	if err != nil {
+		return key, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	if err := tx.Where(SigningKey{KeyID: shared.KeyID(public)}).
+		Assign(SigningKey{Signer: body.Name, PublicKey: base64.StdEncoding.EncodeToString(public)}).
+		FirstOrCreate(&key).Error; err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+// OpenPayload returns the payload of an upload and its signer. An upload signed in a DSSE envelope of the payload type
+// is verified against the registered keys and its payload returned exactly as it was signed, any other one is unsigned.
+func OpenPayload(tx *gorm.DB, content []byte, payloadType string) ([]byte, string, error) {
+	var envelope shared.Envelope
+	if err := json.Unmarshal(content, &envelope); err != nil || envelope.PayloadType == "" {
+		return content, "", nil
+	}
+	payload, err := envelope.Open(payloadType)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", shared.ErrInvalidSignature, err)
+	}
+	signer, err := VerifyEnvelopeSigner(tx, envelope)
+	return payload, signer, err
+}
+
+// VerifyEnvelopeSigner returns the signer of the first signature of the envelope made with a registered key,
+// empty for an envelope without signatures
+func VerifyEnvelopeSigner(tx *gorm.DB, envelope shared.Envelope) (string, error) {
+	if len(envelope.Signatures) == 0 {
+		return "", nil
+	}
+
+	err := ErrUnknownSigningKey
+	for _, signature := range envelope.Signatures {
+		var key SigningKey
+		if findErr := tx.Where("key_id = ?", signature.KeyID).Take(&key).Error; errors.Is(findErr, gorm.ErrRecordNotFound) {
+			continue
+		} else if findErr != nil {
+			return "", findErr
+		}
+
+		public, parseErr := shared.ParsePublicKey(key.PublicKey)
+		if parseErr != nil {
+			return "", parseErr
+		}
+		if err = shared.VerifyEnvelope(envelope, key.KeyID, public); err == nil {
+			return key.Signer, nil
+		}
+	}
+	return "", err
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"fmt"
+	"github.com/b4bay/aspm/internal/shared"
+	"gorm.io/gorm"
+)
+
+// Triage records the decision on the findings it targets and on their twins along the lineage, as the propagation asks.
+// It returns how many findings got the status, gorm.ErrRecordNotFound when no finding matches.
+func Triage(tx *gorm.DB, body shared.TriageMessageBody) (int, error) {
+	var targets []Vulnerability
+	query := tx
+	if body.FindingID != 0 {
+		query = query.Where("id = ?", body.FindingID)
+	} else {
+		// The path is the location without the region, e.g. "main.go" for "main.go(12:3)"
+		query = query.Where("product_id = ? AND vulnerability_id = ? AND (location_hash = ? OR location_hash LIKE ?)",
+			body.ProductID, body.Rule, body.Path, body.Path+"(%")
+	}
+	if err := query.Find(&targets).Error; err != nil {
+		return 0, err
+	}
+	if len(targets) == 0 {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	var count int
+	for _, v := range targets {
+		status := Status{
+			VulnerabilityID: fmt.Sprint(v.ID),
+			Kind:            body.Status,
+			Propagation:     body.Propagation,
+			Reason:          body.Reason,
+			Author:          body.Author,
+		}
+		if err := tx.Create(&status).Error; err != nil {
+			return 0, err
+		}
+		count++
+
+		productIDs, err := PropagationIDs(tx, v.ProductID, body.Propagation)
+		if err != nil {
+			return 0, err
+		}
+		if len(productIDs) == 0 {
+			continue
+		}
+
+		var twins []Vulnerability
+		if err := tx.Where("product_id IN ? AND vulnerability_id = ? AND location_hash = ?", productIDs, v.VulnerabilityID, v.LocationHash).
+			Find(&twins).Error; err != nil {
+			return 0, err
+		}
+		for _, twin := range twins {
+			if err := tx.Create(&Status{
+				VulnerabilityID: fmt.Sprint(twin.ID),
+				Kind:            status.Kind,
+				Propagation:     status.Propagation,
+				Reason:          status.Reason,
+				Author:          status.Author,
+				OriginStatusID:  status.ID,
+			}).Error; err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// InheritStatuses gives new findings of the product the latest decision propagated to it by a product linked to it,
+// e.g. a false positive marked on a commit with forward propagation applies to the binaries built from it later.
+// The lineage is walked once and the decisions on all the findings are read at once, reports bring thousands of findings.
+func InheritStatuses(tx *gorm.DB, productID string, findings []Vulnerability) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	type key struct{ rule, location string }
+	var rules []string
+	pending := map[key][]Vulnerability{}
+	for _, v := range findings {
+		k := key{v.VulnerabilityID, v.LocationHash}
+		if len(pending[k]) == 0 {
+			rules = append(rules, v.VulnerabilityID)
+		}
+		pending[k] = append(pending[k], v)
+	}
+
+	var inherited []Status
+	for _, source := range []struct {
+		forward      bool
+		propagations []shared.StatusPropagation
+	}{
+		{false, []shared.StatusPropagation{shared.PropagationForward, shared.PropagationBidirectional}},
+		{true, []shared.StatusPropagation{shared.PropagationBackward, shared.PropagationBidirectional}},
+	} {
+		if len(pending) == 0 {
+			break
+		}
+		productIDs, err := lineageIDs(tx, productID, source.forward)
+		if err != nil {
+			return err
+		}
+		if len(productIDs) == 0 {
+			continue
+		}
+
+		var statuses []struct {
+			Status
+			Rule     string
+			Location string
+		}
+		if err := tx.Model(&Status{}).Select("statuses.*, vulnerabilities.vulnerability_id AS rule, vulnerabilities.location_hash AS location").
+			Joins("JOIN vulnerabilities ON vulnerabilities.id = statuses.vulnerability_id").
+			Where("vulnerabilities.product_id IN ? AND vulnerabilities.vulnerability_id IN ? AND statuses.propagation IN ?",
+				productIDs, rules, source.propagations).
+			Order("statuses.id DESC").Scan(&statuses).Error; err != nil {
+			return err
+		}
+
+		// Latest first, a finding takes the first decision on its rule and location
+		for _, status := range statuses {
+			k := key{status.Rule, status.Location}
+			originStatusID := status.OriginStatusID
+			if originStatusID == 0 {
+				originStatusID = status.ID
+			}
+			for _, v := range pending[k] {
+				inherited = append(inherited, Status{
+					VulnerabilityID: fmt.Sprint(v.ID),
+					Kind:            status.Kind,
+					Propagation:     status.Propagation,
+					Reason:          status.Reason,
+					Author:          status.Author,
+					OriginStatusID:  originStatusID,
+				})
+			}
+			delete(pending, k)
+		}
+	}
+	if len(inherited) == 0 {
+		return nil
+	}
+
+	return tx.CreateInBatches(&inherited, 100).Error
+}
+
+// PropagationIDs returns the products a decision on a finding of the product propagates to
+func PropagationIDs(tx *gorm.DB, productID string, propagation shared.StatusPropagation) ([]string, error) {
+	var ids []string
+	if propagation == shared.PropagationForward || propagation == shared.PropagationBidirectional {
+		forward, err := lineageIDs(tx, productID, true)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, forward...)
+	}
+	if propagation == shared.PropagationBackward || propagation == shared.PropagationBidirectional {
+		backward, err := lineageIDs(tx, productID, false)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, backward...)
+	}
+	return ids, nil
+}
+
+// LatestStatuses returns the status in effect of each of the findings which have one
+func LatestStatuses(tx *gorm.DB, vulnerabilityIDs []uint) (map[uint]Status, error) {
+	latest := map[uint]Status{}
+	if len(vulnerabilityIDs) == 0 {
+		return latest, nil
+	}
+
+	var ids []string
+	for _, id := range vulnerabilityIDs {
+		ids = append(ids, fmt.Sprint(id))
+	}
+
+	var statuses []Status
+	if err := tx.Where("vulnerability_id IN ?", ids).Order("id").Find(&statuses).Error; err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		var id uint
+		fmt.Sscan(status.VulnerabilityID, &id)
+		latest[id] = status
+	}
+	return latest, nil
+}
+
+// lineageIDs walks the links from the product, forward to the products made of it or backward to its origins
+func lineageIDs(tx *gorm.DB, productID string, forward bool) ([]string, error) {
+	from, to := "product_id", "origin_id"
+	if forward {
+		from, to = to, from
+	}
+
+	// A level of links at a time, like BlastRadius
+	seen := map[string]bool{productID: true}
+	var ids []string
+	for frontier := []string{productID}; len(frontier) > 0; {
+		var next []string
+		if err := tx.Model(&Link{}).Where(from+" IN ?", frontier).Pluck(to, &next).Error; err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for _, id := range next {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+				frontier = append(frontier, id)
+			}
+		}
+	}
+
+	return ids, nil
+}
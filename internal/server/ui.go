@@ -2,34 +2,68 @@ package server
 
 import (
 	"encoding/json"
-	"gorm.io/gorm/clause"
+	"errors"
+	"github.com/b4bay/aspm/internal/shared"
+	"gorm.io/gorm"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 )
 
+// UIProductHandler lists products a page at a time, see Page, filtered by ?project=&type=&ref=&since=&until=
 func UIProductHandler(w http.ResponseWriter, r *http.Request) {
-	// Fetch all products from the database
+	page, err := ParsePage(r, "created_at", "product_id", "name", "type", "project")
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Fetch the products from the database, or the one known by the id or any of its aliases
+	query := DB.Preload("Aliases")
+	if id := r.URL.Query().Get("id"); id != "" {
+		productID, err := ResolveProductID(DB, id)
+		if err != nil {
+			http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+			return
+		}
+		query = query.Where("product_id = ?", productID)
+	}
+	// Or the ones with the package URL, e.g. any arch or distro of a package with ?purl=pkg:deb/debian/curl@7.50.3&ignore_qualifiers=true
+	if purl := r.URL.Query().Get("purl"); purl != "" {
+		parsed, err := shared.ParsePurl(purl)
+		if err != nil {
+			http.Error(w, "Invalid purl: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ignore, _ := strconv.ParseBool(r.URL.Query().Get("ignore_qualifiers")); ignore {
+			query = query.Where("purl_base = ?", parsed.Base())
+		} else {
+			query = query.Where("purl = ?", parsed.String())
+		}
+	}
+	for _, param := range []string{"project", "type", "ref"} {
+		if value := r.URL.Query().Get(param); value != "" {
+			query = query.Where(param+" = ?", value)
+		}
+	}
+	if query, err = CreatedBetween(query, r, "products"); err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var products []Product
-	if err := DB.Preload(clause.Associations).Find(&products).Error; err != nil {
+	if err := page.Apply(query, "products").Find(&products).Error; err != nil {
 		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
 		return
 	}
+	products = Rows(w, r, page, products, func(p Product) uint { return p.ID })
 
 	// Map products to ProductResponse
 	productResponses := []ProductResponse{}
 	for _, product := range products {
-		productResponses = append(productResponses, ProductResponse{
-			ID:        product.ID,
-			ProductID: product.ProductID,
-			Name:      product.Name,
-			Type:      product.Type,
-			Project:   product.Project,
-			Author:    product.Author,
-			Worker:    product.Worker,
-			CreatedAt: product.CreatedAt,
-		})
+		productResponses = append(productResponses, newProductResponse(product))
 	}
 
 	// Set the response header to JSON
@@ -42,22 +76,219 @@ func UIProductHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UIComponentHandler answers which products use a component, e.g. ?purl=pkg:npm/lodash@4.17.21
+func UIComponentHandler(w http.ResponseWriter, r *http.Request) {
+	purl := r.URL.Query().Get("purl")
+	if purl == "" {
+		http.Error(w, "Missing purl", http.StatusBadRequest)
+		return
+	}
+
+	if parsed, err := shared.ParsePurl(purl); err == nil {
+		purl = parsed.String()
+	}
+	componentID, err := ResolveProductID(DB, purl)
+	if err != nil {
+		http.Error(w, "Failed to resolve component", http.StatusInternalServerError)
+		return
+	}
+
+	var links []Link
+	if err := DB.Preload("Product.Aliases").Where("origin_id = ? AND type = ?", componentID, shared.ProductionMethodComponent).
+		Find(&links).Error; err != nil {
+		http.Error(w, "Failed to fetch component links", http.StatusInternalServerError)
+		return
+	}
+
+	productResponses := []ProductResponse{}
+	for _, link := range links {
+		productResponses = append(productResponses, newProductResponse(link.Product))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(productResponses); err != nil {
+		http.Error(w, "Failed to encode products to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UILineageHandler answers where a product came from and where it went, e.g. ?id=<id>&direction=up&depth=3
+func UILineageHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Missing id", http.StatusBadRequest)
+		return
+	}
+
+	var up, down bool
+	switch r.URL.Query().Get("direction") {
+	case "up":
+		up = true
+	case "down":
+		down = true
+	case "", "both":
+		up, down = true, true
+	default:
+		http.Error(w, "Invalid direction", http.StatusBadRequest)
+		return
+	}
+
+	var depth int
+	if value := r.URL.Query().Get("depth"); value != "" {
+		var err error
+		if depth, err = strconv.Atoi(value); err != nil || depth < 0 {
+			http.Error(w, "Invalid depth", http.StatusBadRequest)
+			return
+		}
+	}
+
+	productID, err := ResolveProductID(DB, id)
+	if err != nil {
+		http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+		return
+	}
+
+	lineage, err := Lineage(DB, productID, up, down, depth)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch lineage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(lineage); err != nil {
+		http.Error(w, "Failed to encode lineage to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ProductDetailHandler answers everything known about one product, known by its id or any of its aliases.
+// The id may contain slashes, as package URLs do.
+func ProductDetailHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := ResolveProductID(DB, r.PathValue("productId"))
+	if err != nil {
+		http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+		return
+	}
+
+	detail, err := ProductDetail(DB, productID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch product", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(detail); err != nil {
+		http.Error(w, "Failed to encode product to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UIBlastRadiusHandler answers what is made of the products affected by one of ?cve=, ?purl= or ?finding=<id>
+func UIBlastRadiusHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var given int
+	for _, param := range []string{"cve", "purl", "finding"} {
+		if query.Get(param) != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		http.Error(w, "One of cve, purl or finding required", http.StatusBadRequest)
+		return
+	}
+
+	var affectedIDs []string
+	var err error
+	switch {
+	case query.Get("cve") != "":
+		affectedIDs, err = AffectedByCVE(DB, strings.ToUpper(query.Get("cve")))
+	case query.Get("purl") != "":
+		parsed, parseErr := shared.ParsePurl(query.Get("purl"))
+		if parseErr != nil {
+			http.Error(w, "Invalid purl: "+parseErr.Error(), http.StatusBadRequest)
+			return
+		}
+		affectedIDs, err = AffectedByPurl(DB, parsed)
+	default:
+		id, parseErr := strconv.ParseUint(query.Get("finding"), 10, 64)
+		if parseErr != nil {
+			http.Error(w, "Invalid finding", http.StatusBadRequest)
+			return
+		}
+		affectedIDs, err = AffectedByFinding(DB, uint(id))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Finding not found", http.StatusNotFound)
+			return
+		}
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch affected products", http.StatusInternalServerError)
+		return
+	}
+
+	radius, err := BlastRadius(DB, affectedIDs)
+	if err != nil {
+		http.Error(w, "Failed to fetch blast radius", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(radius); err != nil {
+		http.Error(w, "Failed to encode blast radius to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// UILinkHandler lists links a page at a time, see Page, filtered by ?product=&origin=&type=&since=&until=
 func UILinkHandler(w http.ResponseWriter, r *http.Request) {
-	// Fetch all links from the database
+	page, err := ParsePage(r, "created_at", "product_id", "origin_id", "type")
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Links know the ids of their products, the products themselves are not needed
+	query := DB.Model(&Link{})
+	for param, column := range map[string]string{"product": "product_id", "origin": "origin_id"} {
+		if id := r.URL.Query().Get(param); id != "" {
+			productID, err := ResolveProductID(DB, id)
+			if err != nil {
+				http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+				return
+			}
+			query = query.Where(column+" = ?", productID)
+		}
+	}
+	if method := r.URL.Query().Get("type"); method != "" {
+		query = query.Where("type = ?", method)
+	}
+	if query, err = CreatedBetween(query, r, "links"); err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var links []Link
-	if err := DB.Preload(clause.Associations).Find(&links).Error; err != nil {
+	if err := page.Apply(query, "links").Find(&links).Error; err != nil {
 		http.Error(w, "Failed to fetch links", http.StatusInternalServerError)
 		return
 	}
+	links = Rows(w, r, page, links, func(l Link) uint { return l.ID })
 
 	// Map links to LinkResponse
 	linkResponses := []LinkResponse{}
 	for _, link := range links {
 		linkResponses = append(linkResponses, LinkResponse{
 			ID:        link.ID,
-			ProductID: link.Product.ProductID,
-			OriginID:  link.Origin.ProductID,
+			ProductID: link.ProductID,
+			OriginID:  link.OriginID,
 			Type:      link.Type,
+			Signer:    link.Signer,
 			CreatedAt: link.CreatedAt,
 		})
 	}
@@ -72,24 +303,46 @@ func UILinkHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UIEngagementHandler lists engagements a page at a time, see Page, filtered by ?product=&project=&tool=&since=&until=
 func UIEngagementHandler(w http.ResponseWriter, r *http.Request) {
-	// Fetch all engagements from the database
-	var engagements []Engagement
-	if err := DB.Preload(clause.Associations).Find(&engagements).Error; err != nil {
+	page, err := ParsePage(r, "created_at", "product_id", "tool")
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Raw reports are never loaded, only their length
+	query := DB.Model(&Engagement{}).Select(engagementColumns)
+	if id := r.URL.Query().Get("product"); id != "" {
+		productID, err := ResolveProductID(DB, id)
+		if err != nil {
+			http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+			return
+		}
+		query = query.Where("product_id = ?", productID)
+	}
+	if project := r.URL.Query().Get("project"); project != "" {
+		query = query.Where("product_id IN (?)", DB.Model(&Product{}).Select("product_id").Where("project = ?", project))
+	}
+	if tool := r.URL.Query().Get("tool"); tool != "" {
+		query = query.Where("tool = ?", tool)
+	}
+	if query, err = CreatedBetween(query, r, "engagements"); err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var engagements []engagementRow
+	if err := page.Apply(query, "engagements").Find(&engagements).Error; err != nil {
 		http.Error(w, "Failed to fetch engagements", http.StatusInternalServerError)
 		return
 	}
+	engagements = Rows(w, r, page, engagements, func(e engagementRow) uint { return e.ID })
 
 	// Map Engagement to EngagementResponse
 	engagementResponses := []EngagementResponse{}
 	for _, engagement := range engagements {
-		engagementResponses = append(engagementResponses, EngagementResponse{
-			ID:           engagement.ID,
-			ProductID:    engagement.Product.ProductID,
-			Tool:         engagement.Tool,
-			ReportLength: len(engagement.RawReport),
-			CreatedAt:    engagement.CreatedAt,
-		})
+		engagementResponses = append(engagementResponses, newEngagementResponse(engagement))
 	}
 
 	// Set the response header to JSON
@@ -102,29 +355,67 @@ func UIEngagementHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UIVulnerabilityHandler lists findings a page at a time, see Page,
+// filtered by ?product=&project=&tool=&level=&cwe=&cve=&status=&since=&until=
 func UIVulnerabilityHandler(w http.ResponseWriter, r *http.Request) {
-	// Fetch all links from the database
+	page, err := ParsePage(r, "created_at", "product_id", "vulnerability_id", "level", "cwe", "cve")
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Findings of a product include the ones of its stripped or debug twins
+	query := DB.Model(&Vulnerability{})
+	if id := r.URL.Query().Get("product"); id != "" {
+		productID, err := ResolveProductID(DB, id)
+		if err != nil {
+			http.Error(w, "Failed to resolve product", http.StatusInternalServerError)
+			return
+		}
+		productIDs, err := StripTwinIDs(DB, productID)
+		if err != nil {
+			http.Error(w, "Failed to fetch product lineage", http.StatusInternalServerError)
+			return
+		}
+		query = query.Where("product_id IN ?", productIDs)
+	}
+	if project := r.URL.Query().Get("project"); project != "" {
+		query = query.Where("product_id IN (?)", DB.Model(&Product{}).Select("product_id").Where("project = ?", project))
+	}
+	if level := r.URL.Query().Get("level"); level != "" {
+		query = query.Where("level = ?", strings.ToLower(level))
+	}
+	// Tools report the weakness as "79" or "CWE-79"
+	if cwe := r.URL.Query().Get("cwe"); cwe != "" {
+		number := strings.TrimPrefix(strings.ToUpper(cwe), "CWE-")
+		query = query.Where("cwe IN ?", []string{number, "CWE-" + number})
+	}
+	if cve := r.URL.Query().Get("cve"); cve != "" {
+		query = query.Where("cve = ?", strings.ToUpper(cve))
+	}
+	if tool := r.URL.Query().Get("tool"); tool != "" {
+		query = query.Where("engagement_id IN (?)", DB.Model(&Engagement{}).Select("id").Where("tool = ?", tool))
+	}
+	if query, err = CreatedBetween(query, r, "vulnerabilities"); err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = WhereStatus(DB, query, status)
+	}
+
 	var vulnerabilities []Vulnerability
-	if err := DB.Preload(clause.Associations).Find(&vulnerabilities).Error; err != nil {
+	if err := page.Apply(query, "vulnerabilities").Find(&vulnerabilities).Error; err != nil {
 		http.Error(w, "Failed to fetch vulnerabilities", http.StatusInternalServerError)
 		return
 	}
+	vulnerabilities = Rows(w, r, page, vulnerabilities, func(v Vulnerability) uint { return v.ID })
 
-	// Map Engagement to EngagementResponse
-	vulnerabilityResponses := []VulnerabilityResponse{}
-	for _, vulnerability := range vulnerabilities {
-		vulnerabilityResponses = append(vulnerabilityResponses, VulnerabilityResponse{
-			ID:              vulnerability.ID,
-			VulnerabilityID: vulnerability.VulnerabilityID,
-			ProductID:       vulnerability.Product.ProductID,
-			LocationHash:    vulnerability.LocationHash,
-			Level:           vulnerability.Level,
-			Text:            vulnerability.Text,
-			CWE:             vulnerability.CWE,
-			CVE:             vulnerability.CVE,
-			EngagementID:    vulnerability.Engagement.ID,
-			CreatedAt:       vulnerability.CreatedAt,
-		})
+	vulnerabilityResponses, err := newVulnerabilityResponses(DB, vulnerabilities)
+	if err != nil {
+		http.Error(w, "Failed to fetch statuses", http.StatusInternalServerError)
+		return
 	}
 
 	// Set the response header to JSON
@@ -165,3 +456,71 @@ func UIVersionHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to encode version to JSON", http.StatusInternalServerError)
 	}
 }
+
+// newVulnerabilityResponses maps the findings with the status in effect of each
+func newVulnerabilityResponses(tx *gorm.DB, vulnerabilities []Vulnerability) ([]VulnerabilityResponse, error) {
+	var ids []uint
+	for _, vulnerability := range vulnerabilities {
+		ids = append(ids, vulnerability.ID)
+	}
+	statuses, err := LatestStatuses(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	vulnerabilityResponses := []VulnerabilityResponse{}
+	for _, vulnerability := range vulnerabilities {
+		vulnerabilityResponses = append(vulnerabilityResponses, VulnerabilityResponse{
+			ID:              vulnerability.ID,
+			VulnerabilityID: vulnerability.VulnerabilityID,
+			ProductID:       vulnerability.ProductID,
+			LocationHash:    vulnerability.LocationHash,
+			Level:           vulnerability.Level,
+			Text:            vulnerability.Text,
+			CWE:             vulnerability.CWE,
+			CVE:             vulnerability.CVE,
+			FileHash:        vulnerability.FileHash,
+			Fingerprint:     vulnerability.Fingerprint(),
+			Status:          string(statuses[vulnerability.ID].Kind),
+			StatusReason:    statuses[vulnerability.ID].Reason,
+			EngagementID:    vulnerability.EngagementID,
+			CreatedAt:       vulnerability.CreatedAt,
+		})
+	}
+	return vulnerabilityResponses, nil
+}
+
+// newProductResponse maps the product, aliases are listed when preloaded
+func newProductResponse(product Product) ProductResponse {
+	var aliases []string
+	for _, alias := range product.Aliases {
+		aliases = append(aliases, alias.Alias)
+	}
+	return ProductResponse{
+		ID:        product.ID,
+		ProductID: product.ProductID,
+		Name:      product.Name,
+		Type:      product.Type,
+		Project:   product.Project,
+		Ref:       product.Ref,
+		Author:    product.Author,
+		Worker:    product.Worker,
+		Dirty:     product.Dirty,
+		BuildID:   product.BuildID,
+		Purl:      product.Purl,
+		CPE:       product.CPE,
+		Aliases:   aliases,
+		CreatedAt: product.CreatedAt,
+	}
+}
+
+func newEngagementResponse(engagement engagementRow) EngagementResponse {
+	return EngagementResponse{
+		ID:           engagement.ID,
+		ProductID:    engagement.ProductID,
+		Tool:         engagement.Tool,
+		ReportLength: engagement.ReportLength,
+		Signer:       engagement.Signer,
+		CreatedAt:    engagement.CreatedAt,
+	}
+}
@@ -1,33 +1,43 @@
 package server
 
 import (
-	"strings"
+	"github.com/b4bay/aspm/internal/shared"
+	"os"
 )
 
-func GetProjectFromEnvironment(e map[string]string) string {
-	project, ok := e["CI_PROJECT_PATH"]
-	if ok {
-		return project
-	} else {
-		return ""
+// EnvironmentFilter drops variables outside the allow-list before anything is logged or persisted.
+// Extra variables are allowed with ASPM_ENV_INCLUDE, known ones are denied with ASPM_ENV_EXCLUDE.
+var EnvironmentFilter = shared.NewEnvironmentFilter(os.Getenv("ASPM_ENV_INCLUDE"), os.Getenv("ASPM_ENV_EXCLUDE"))
+
+// AdminKey protects administrative operations, they are disabled when ASPM_ADMIN_KEY is not set
+var AdminKey = os.Getenv("ASPM_ADMIN_KEY")
+
+// TriageKey allows decisions on findings, as the admin key does. Decisions make the gate pass,
+// so triage is disabled when neither ASPM_TRIAGE_KEY nor ASPM_ADMIN_KEY is set.
+var TriageKey = os.Getenv("ASPM_TRIAGE_KEY")
+
+// RequireSignature rejects unsigned collect and origin uploads when ASPM_REQUIRE_SIGNATURE is true,
+// otherwise they are accepted and recorded without a signer
+var RequireSignature = os.Getenv("ASPM_REQUIRE_SIGNATURE") == "true"
+
+// GetCIContext prefers the context resolved by the CLI and fills the gaps from the environment
+func GetCIContext(ci *shared.CIContext, e map[string]string) shared.CIContext {
+	var c shared.CIContext
+	if ci != nil {
+		c = *ci
 	}
+
+	return c.Merge(shared.GetCIContext(e))
+}
+
+func GetProjectFromEnvironment(e map[string]string) string {
+	return shared.GetCIContext(e).Project
 }
 
 func GetAuthorFromEnvironment(e map[string]string) string {
-	author, ok := e["GITLAB_USER_NAME"]
-	if ok {
-		return author
-	} else {
-		return ""
-	}
+	return shared.GetCIContext(e).Author
 }
 
 func GetWorkerFromEnvironment(e map[string]string) string {
-	worker, ok := e["CI_RUNNER_DESCRIPTION"]
-	if ok {
-		worker := strings.SplitN(worker, "/", 2)[0]
-		return worker
-	} else {
-		return ""
-	}
+	return shared.GetCIContext(e).Worker
 }
@@ -2,7 +2,9 @@ package server
 
 import (
 	"github.com/b4bay/aspm/internal/server/sarif"
+	"github.com/b4bay/aspm/internal/shared"
 	"gorm.io/gorm"
+	"time"
 )
 
 type Vulnerability struct {
@@ -14,36 +16,36 @@ type Vulnerability struct {
 	Text            string
 	CWE             string
 	CVE             string
-	EngagementID    uint `gorm:"index;not null"`
+	FileHash        string // SHA-256 of the file at the location, known for dir artefacts only
+	Tool            string // Driver of the run the finding was reported in
+	ToolFingerprint string // See sarif.Result.Fingerprint
+	EngagementID    uint   `gorm:"index;not null"`
 
 	// Associations
 	Product    Product    `gorm:"constraint:OnDelete:CASCADE;foreignKey:ProductID;references:ProductID"`
 	Engagement Engagement `gorm:"constraint:OnDelete:CASCADE;foreignKey:EngagementID;references:ID"`
 }
 
-type StatusPropagation string
-
-const (
-	Forward       StatusPropagation = "forward"
-	Backward      StatusPropagation = "backward"
-	Bidirectional StatusPropagation = "bidirectional"
-)
-
-type StatusKind string
-
-const (
-	NoImpact      StatusKind = "no_impact"
-	Confirmed     StatusKind = "confirmed"
-	FalsePositive StatusKind = "false_positive"
-	// TODO: https://help.sonatype.com/en/reviewing-security-vulnerabilities.html
-)
-
+// Status is a decision on a finding, the latest one is in effect
 type Status struct {
-	ID              uint64     `gorm:"primaryKey"`
-	VulnerabilityID string     `gorm:"index;not null"`
-	Kind            StatusKind `gorm:"index;not null"`
-	Propagation     StatusPropagation
+	ID              uint64            `gorm:"primaryKey"`
+	VulnerabilityID string            `gorm:"index;not null"`
+	Kind            shared.StatusKind `gorm:"index;not null"`
+	Propagation     shared.StatusPropagation
+	Reason          string
+	Author          string
+	OriginStatusID  uint64 `gorm:"index"` // Status propagated to this finding, zero for a decision made on it
+	CreatedAt       time.Time
 
 	// Associations
 	Vulnerability Vulnerability `gorm:"constraint:OnDelete:CASCADE;foreignKey:VulnerabilityID;references:ID"`
 }
+
+// WhereStatus keeps the findings with the status in effect, the latest one, "open" findings have none
+func WhereStatus(tx *gorm.DB, query *gorm.DB, status string) *gorm.DB {
+	if status == "open" {
+		return query.Where("id NOT IN (?)", tx.Model(&Status{}).Select("vulnerability_id"))
+	}
+	latest := tx.Model(&Status{}).Select("MAX(id)").Group("vulnerability_id")
+	return query.Where("id IN (?)", tx.Model(&Status{}).Select("vulnerability_id").Where("id IN (?) AND kind = ?", latest, status))
+}
@@ -0,0 +1,221 @@
+package shared
+
+import (
+	"strings"
+)
+
+// CIContext describes the pipeline which produced or checked an artefact
+type CIContext struct {
+	Provider string `json:"provider"`
+	Project  string `json:"project"`
+	Ref      string `json:"ref"`      // Branch or tag name
+	Revision string `json:"revision"` // Commit being built
+	Author   string `json:"author"`   // Who triggered the pipeline
+	Worker   string `json:"worker"`   // Runner or agent executing the job
+	URL      string `json:"url"`      // Link to the pipeline or job
+}
+
+// Merge fills empty fields of the context from the other one
+func (c CIContext) Merge(o CIContext) CIContext {
+	if c.Provider == "" {
+		c.Provider = o.Provider
+	}
+	if c.Project == "" {
+		c.Project = o.Project
+	}
+	if c.Ref == "" {
+		c.Ref = o.Ref
+	}
+	if c.Revision == "" {
+		c.Revision = o.Revision
+	}
+	if c.Author == "" {
+		c.Author = o.Author
+	}
+	if c.Worker == "" {
+		c.Worker = o.Worker
+	}
+	if c.URL == "" {
+		c.URL = o.URL
+	}
+	return c
+}
+
+// CIProvider reads the pipeline context from the environment variables set by a CI system
+type CIProvider interface {
+	Name() string
+	Detect(env map[string]string) bool
+	Context(env map[string]string) CIContext
+}
+
+type ciProvider struct {
+	name    string
+	marker  string // Variable set by the CI system in every job
+	context func(env map[string]string) CIContext
+}
+
+func (p ciProvider) Name() string {
+	return p.name
+}
+
+func (p ciProvider) Detect(env map[string]string) bool {
+	return env[p.marker] != ""
+}
+
+func (p ciProvider) Context(env map[string]string) CIContext {
+	c := p.context(env)
+	c.Provider = p.name
+	return c
+}
+
+var (
+	GitLabProvider CIProvider = ciProvider{
+		name:   "gitlab",
+		marker: "GITLAB_CI",
+		context: func(env map[string]string) CIContext {
+			return CIContext{
+				Project:  env["CI_PROJECT_PATH"],
+				Ref:      env["CI_COMMIT_REF_NAME"],
+				Revision: env["CI_COMMIT_SHA"],
+				Author:   env["GITLAB_USER_NAME"],
+				Worker:   strings.SplitN(env["CI_RUNNER_DESCRIPTION"], "/", 2)[0],
+				URL:      env["CI_JOB_URL"],
+			}
+		},
+	}
+	GitHubProvider CIProvider = ciProvider{
+		name:   "github",
+		marker: "GITHUB_ACTIONS",
+		context: func(env map[string]string) CIContext {
+			c := CIContext{
+				Project:  env["GITHUB_REPOSITORY"],
+				Ref:      firstOf(env["GITHUB_HEAD_REF"], env["GITHUB_REF_NAME"]),
+				Revision: env["GITHUB_SHA"],
+				Author:   firstOf(env["GITHUB_TRIGGERING_ACTOR"], env["GITHUB_ACTOR"]),
+				Worker:   env["RUNNER_NAME"],
+			}
+			if env["GITHUB_SERVER_URL"] != "" && c.Project != "" && env["GITHUB_RUN_ID"] != "" {
+				c.URL = env["GITHUB_SERVER_URL"] + "/" + c.Project + "/actions/runs/" + env["GITHUB_RUN_ID"]
+			}
+			return c
+		},
+	}
+	JenkinsProvider CIProvider = ciProvider{
+		name:   "jenkins",
+		marker: "JENKINS_URL",
+		context: func(env map[string]string) CIContext {
+			return CIContext{
+				Project:  firstOf(ProjectFromURL(env["GIT_URL"]), env["JOB_NAME"]),
+				Ref:      firstOf(env["BRANCH_NAME"], strings.TrimPrefix(env["GIT_BRANCH"], "origin/")),
+				Revision: env["GIT_COMMIT"],
+				Author:   env["CHANGE_AUTHOR"],
+				Worker:   env["NODE_NAME"],
+				URL:      env["BUILD_URL"],
+			}
+		},
+	}
+	AzureProvider CIProvider = ciProvider{
+		name:   "azure",
+		marker: "TF_BUILD",
+		context: func(env map[string]string) CIContext {
+			c := CIContext{
+				Project:  firstOf(env["BUILD_REPOSITORY_NAME"], env["SYSTEM_TEAMPROJECT"]),
+				Ref:      firstOf(strings.TrimPrefix(env["SYSTEM_PULLREQUEST_SOURCEBRANCH"], "refs/heads/"), env["BUILD_SOURCEBRANCHNAME"]),
+				Revision: env["BUILD_SOURCEVERSION"],
+				Author:   env["BUILD_REQUESTEDFOR"],
+				Worker:   env["AGENT_NAME"],
+			}
+			if env["SYSTEM_COLLECTIONURI"] != "" && env["SYSTEM_TEAMPROJECT"] != "" && env["BUILD_BUILDID"] != "" {
+				c.URL = strings.TrimSuffix(env["SYSTEM_COLLECTIONURI"], "/") + "/" + env["SYSTEM_TEAMPROJECT"] + "/_build/results?buildId=" + env["BUILD_BUILDID"]
+			}
+			return c
+		},
+	}
+	BitbucketProvider CIProvider = ciProvider{
+		name:   "bitbucket",
+		marker: "BITBUCKET_BUILD_NUMBER",
+		context: func(env map[string]string) CIContext {
+			c := CIContext{
+				Project:  env["BITBUCKET_REPO_FULL_NAME"],
+				Ref:      firstOf(env["BITBUCKET_BRANCH"], env["BITBUCKET_TAG"]),
+				Revision: env["BITBUCKET_COMMIT"],
+				Author:   env["BITBUCKET_STEP_TRIGGERER_UUID"],
+			}
+			if env["BITBUCKET_GIT_HTTP_ORIGIN"] != "" {
+				c.URL = env["BITBUCKET_GIT_HTTP_ORIGIN"] + "/pipelines/results/" + env["BITBUCKET_BUILD_NUMBER"]
+			}
+			return c
+		},
+	}
+	CircleCIProvider CIProvider = ciProvider{
+		name:   "circleci",
+		marker: "CIRCLECI",
+		context: func(env map[string]string) CIContext {
+			c := CIContext{
+				Ref:      firstOf(env["CIRCLE_BRANCH"], env["CIRCLE_TAG"]),
+				Revision: env["CIRCLE_SHA1"],
+				Author:   env["CIRCLE_USERNAME"],
+				URL:      env["CIRCLE_BUILD_URL"],
+			}
+			if env["CIRCLE_PROJECT_USERNAME"] != "" && env["CIRCLE_PROJECT_REPONAME"] != "" {
+				c.Project = env["CIRCLE_PROJECT_USERNAME"] + "/" + env["CIRCLE_PROJECT_REPONAME"]
+			}
+			return c
+		},
+	}
+)
+
+// CIProviders are checked in order, the first one detected wins
+var CIProviders = []CIProvider{GitLabProvider, GitHubProvider, JenkinsProvider, AzureProvider, BitbucketProvider, CircleCIProvider}
+
+// DetectCIProvider returns the CI system which set the environment, or nil outside of CI
+func DetectCIProvider(env map[string]string) CIProvider {
+	for _, p := range CIProviders {
+		if p.Detect(env) {
+			return p
+		}
+	}
+	return nil
+}
+
+// GetCIContext reads the pipeline context from the environment, empty one outside of CI
+func GetCIContext(env map[string]string) CIContext {
+	if p := DetectCIProvider(env); p != nil {
+		return p.Context(env)
+	}
+
+	// Environments collected before detection markers were sent, GitLab was the only CI supported then
+	c := GitLabProvider.Context(env)
+	c.Provider = ""
+	return c
+}
+
+// ProjectFromURL turns a repository URL into the project path, e.g. https://host/group/repo.git into group/repo
+func ProjectFromURL(url string) string {
+	if url == "" {
+		return ""
+	}
+
+	path := url
+	if i := strings.Index(path, "://"); i >= 0 {
+		path = path[i+3:]
+		if j := strings.Index(path, "/"); j >= 0 {
+			path = path[j+1:]
+		} else {
+			path = ""
+		}
+	} else if i := strings.Index(path, ":"); i >= 0 { // scp-like syntax, git@host:group/repo.git
+		path = path[i+1:]
+	}
+
+	return strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -1,15 +1,34 @@
 package shared
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
 type CliMode string
 
 const (
-	CliModeCollect CliMode = "collect"
-	CliModeGW      CliMode = "gw"
-	CliModeOrigin  CliMode = "origin"
-	CliModeDefault         = CliModeCollect
+	CliModeCollect     CliMode = "collect"
+	CliModeGW          CliMode = "gw"
+	CliModeOrigin      CliMode = "origin"
+	CliModeFlush       CliMode = "flush"
+	CliModeConfig      CliMode = "config"
+	CliModeMerge       CliMode = "merge"
+	CliModeProducts    CliMode = "products"
+	CliModeFindings    CliMode = "findings"
+	CliModeEngagements CliMode = "engagements"
+	CliModeShow        CliMode = "show"
+	CliModeTriage      CliMode = "triage"
+	CliModeGraph       CliMode = "graph"
+	CliModeUpload      CliMode = "upload"
+	CliModeKeygen      CliMode = "keygen"
+	CliModeBlast       CliMode = "blast"
+	CliModeDefault             = CliModeCollect
 )
 
-var AllowedCliModes = []CliMode{CliModeCollect, CliModeGW, CliModeOrigin}
+var AllowedCliModes = []CliMode{CliModeCollect, CliModeGW, CliModeOrigin, CliModeFlush, CliModeConfig, CliModeMerge,
+	CliModeProducts, CliModeFindings, CliModeEngagements, CliModeShow, CliModeTriage, CliModeGraph, CliModeUpload, CliModeKeygen, CliModeBlast}
 
 func IsValidCliMode(cliMode CliMode) bool {
 	for _, a := range AllowedCliModes {
@@ -25,10 +44,13 @@ type ArtefactType string
 const (
 	ArtefactTypeGit     ArtefactType = "git"
 	ArtefactTypeBin     ArtefactType = "bin"
+	ArtefactTypeImage   ArtefactType = "image"
+	ArtefactTypeDir     ArtefactType = "dir"
+	ArtefactTypePackage ArtefactType = "package" // Third party component, identified by its purl
 	ArtefactTypeDefault ArtefactType = ArtefactTypeGit
 )
 
-var AllowedArtefactTypes = []ArtefactType{ArtefactTypeGit, ArtefactTypeBin}
+var AllowedArtefactTypes = []ArtefactType{ArtefactTypeGit, ArtefactTypeBin, ArtefactTypeImage, ArtefactTypeDir, ArtefactTypePackage}
 
 func IsValidArtefactType(artefactType ArtefactType) bool {
 	for _, a := range AllowedArtefactTypes {
@@ -42,12 +64,15 @@ func IsValidArtefactType(artefactType ArtefactType) bool {
 type ProductionMethod string
 
 const (
-	ProductionMethodCompile ProductionMethod = "compile"
-	ProductionMethodPack    ProductionMethod = "pack"
-	ProductionMethodDefault ProductionMethod = ProductionMethodCompile
+	ProductionMethodCompile      ProductionMethod = "compile"
+	ProductionMethodPack         ProductionMethod = "pack"
+	ProductionMethodContainerize ProductionMethod = "containerize"
+	ProductionMethodComponent    ProductionMethod = "component" // Origin is a third party component built into the product
+	ProductionMethodStrip        ProductionMethod = "strip"     // Origin is the debug build the product was stripped from
+	ProductionMethodDefault      ProductionMethod = ProductionMethodCompile
 )
 
-var AllowedProductionMethods = []ProductionMethod{ProductionMethodCompile, ProductionMethodPack}
+var AllowedProductionMethods = []ProductionMethod{ProductionMethodCompile, ProductionMethodPack, ProductionMethodContainerize, ProductionMethodComponent, ProductionMethodStrip}
 
 func IsValidProductionMethod(productionMethod ProductionMethod) bool {
 	for _, a := range AllowedProductionMethods {
@@ -58,22 +83,130 @@ func IsValidProductionMethod(productionMethod ProductionMethod) bool {
 	return false
 }
 
+type StatusKind string
+
+const (
+	StatusNoImpact      StatusKind = "no_impact"
+	StatusConfirmed     StatusKind = "confirmed"
+	StatusFalsePositive StatusKind = "false_positive"
+	// TODO: https://help.sonatype.com/en/reviewing-security-vulnerabilities.html
+)
+
+var AllowedStatusKinds = []StatusKind{StatusNoImpact, StatusConfirmed, StatusFalsePositive}
+
+func IsValidStatusKind(kind StatusKind) bool {
+	for _, a := range AllowedStatusKinds {
+		if a == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusPropagation tells which products linked to the one of the finding share the decision
+type StatusPropagation string
+
+const (
+	PropagationNone          StatusPropagation = ""
+	PropagationForward       StatusPropagation = "forward"  // To products made of the product, e.g. binaries compiled from a commit
+	PropagationBackward      StatusPropagation = "backward" // To the origins of the product
+	PropagationBidirectional StatusPropagation = "bidirectional"
+)
+
+var AllowedStatusPropagations = []StatusPropagation{PropagationNone, PropagationForward, PropagationBackward, PropagationBidirectional}
+
+func IsValidStatusPropagation(propagation StatusPropagation) bool {
+	for _, a := range AllowedStatusPropagations {
+		if a == propagation {
+			return true
+		}
+	}
+	return false
+}
+
 type ProductMessage struct {
-	Id     string       `json:"id"`
-	Name   string       `json:"name"`
-	Type   ArtefactType `json:"type"`
-	Author string       `json:"author"`
+	Id      string            `json:"id"`
+	Name    string            `json:"name"`
+	Type    ArtefactType      `json:"type"`
+	Author  string            `json:"author"`
+	Files   map[string]string `json:"files,omitempty"`    // SHA-256 of every file by relative path, dir artefacts only
+	Digests map[string]string `json:"digests,omitempty"`  // Hex digests by algorithm, see DigestAlgorithms
+	Dirty   bool              `json:"dirty,omitempty"`    // Built from a source tree with uncommitted changes
+	BuildID string            `json:"build_id,omitempty"` // ELF build-id, shared by a stripped binary and its debug build
+	Purl    string            `json:"purl,omitempty"`
+	CPE     string            `json:"cpe,omitempty"`
+}
+
+// DigestAlgorithms are the digests the CLI computes for files, SHA-1 is the product id and the rest are aliases
+var DigestAlgorithms = []string{"sha1", "sha256", "sha512"}
+
+// Aliases returns the digests in "<algorithm>:<hex>" form, the way registries and SBOMs refer to artefacts.
+// The purl is not an alias, distinct artefacts such as the builds of a package for several platforms share it.
+func (m ProductMessage) Aliases() []string {
+	var aliases []string
+	for algorithm, digest := range m.Digests {
+		if digest != "" {
+			aliases = append(aliases, algorithm+":"+digest)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
 }
 
 type OriginMessageBody struct {
 	Environment      map[string]string `json:"environment"`
+	CI               *CIContext        `json:"ci,omitempty"`
 	Product          ProductMessage    `json:"product"`
 	Origins          []ProductMessage  `json:"origins"`
 	ProductionMethod ProductionMethod  `json:"production_method"`
 }
 
 type CollectMessageBody struct {
-	Environment map[string]string `json:"environment"`
-	Artefact    ProductMessage    `json:"artefact"`
-	Reports     map[string]string `json:"reports"`
+	Environment    map[string]string `json:"environment"`
+	CI             *CIContext        `json:"ci,omitempty"`
+	Artefact       ProductMessage    `json:"artefact"`
+	Reports        map[string]string `json:"reports"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+}
+
+type MergeMessageBody struct {
+	From string `json:"from"` // Product merged and removed
+	Into string `json:"into"` // Product kept
+}
+
+// KeyMessageBody registers the public key of a signer, e.g. a pipeline, under its name
+type KeyMessageBody struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"` // PKIX PEM or raw key in base64
+}
+
+// TriageMessageBody records a decision on a finding, known by its id or by rule and path in a product
+type TriageMessageBody struct {
+	FindingID   uint              `json:"finding_id,omitempty"`
+	ProductID   string            `json:"product_id,omitempty"`
+	Rule        string            `json:"rule,omitempty"`
+	Path        string            `json:"path,omitempty"`
+	Status      StatusKind        `json:"status"`
+	Reason      string            `json:"reason,omitempty"`
+	Propagation StatusPropagation `json:"propagation,omitempty"`
+	Author      string            `json:"author,omitempty"`
+}
+
+// CollectIdempotencyKey derives the key of a collect upload from the artefact id and the content of its reports.
+// Report names are not part of the key, so the same reports uploaded under other file names are still a repeat.
+func CollectIdempotencyKey(artefactId string, reports map[string]string) string {
+	var reportHashes []string
+	for _, report := range reports {
+		h := sha256.Sum256([]byte(report))
+		reportHashes = append(reportHashes, hex.EncodeToString(h[:]))
+	}
+	sort.Strings(reportHashes)
+
+	hasher := sha256.New()
+	hasher.Write([]byte(artefactId))
+	for _, h := range reportHashes {
+		hasher.Write([]byte("\n" + h))
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil))
 }
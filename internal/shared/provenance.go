@@ -0,0 +1,249 @@
+package shared
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	StatementType          = "https://in-toto.io/Statement/v1"
+	ProvenancePredicate    = "https://slsa.dev/provenance/v1"
+	ProvenanceBuildType    = "https://github.com/b4bay/aspm/origin/v1"
+	ProvenanceBuilderID    = "https://github.com/b4bay/aspm/cli"
+	EnvelopePayloadType    = "application/vnd.in-toto+json"
+	annotationArtefactID   = "aspm.id"
+	annotationArtefactType = "aspm.type"
+)
+
+// Statement is an in-toto attestation statement, the CLI makes SLSA provenance ones
+type Statement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     Provenance           `json:"predicate"`
+}
+
+// ResourceDescriptor is an artefact of a statement, known by its digests
+type ResourceDescriptor struct {
+	Name        string            `json:"name,omitempty"`
+	URI         string            `json:"uri,omitempty"`
+	Digest      map[string]string `json:"digest,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Provenance is the SLSA provenance v1 predicate
+type Provenance struct {
+	BuildDefinition struct {
+		BuildType            string                 `json:"buildType"`
+		ExternalParameters   map[string]interface{} `json:"externalParameters"`
+		ResolvedDependencies []ResourceDescriptor   `json:"resolvedDependencies,omitempty"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Metadata struct {
+			InvocationID string `json:"invocationId,omitempty"`
+		} `json:"metadata,omitempty"`
+	} `json:"runDetails"`
+}
+
+// Envelope is a DSSE envelope, the way attestations are signed and stored in .intoto.jsonl files
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"` // Base64 encoded statement, or collect and origin payload
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Descriptor describes the product for a statement, the id and type are annotations so the server gets the product back as it was
+func (m ProductMessage) Descriptor() ResourceDescriptor {
+	descriptor := ResourceDescriptor{
+		Name:        m.Name,
+		Digest:      map[string]string{},
+		Annotations: map[string]string{annotationArtefactID: m.Id, annotationArtefactType: string(m.Type)},
+	}
+	if m.Purl != "" {
+		descriptor.URI = m.Purl
+	}
+
+	for algorithm, digest := range m.Digests {
+		descriptor.Digest[algorithm] = digest
+	}
+	switch algorithm, digest, found := strings.Cut(m.Id, ":"); {
+	case m.Type == ArtefactTypeGit:
+		descriptor.Digest["gitCommit"] = m.Id
+	case found:
+		descriptor.Digest[algorithm] = digest
+	case len(descriptor.Digest) == 0 && m.Type == ArtefactTypeBin:
+		descriptor.Digest["sha1"] = m.Id
+	}
+	return descriptor
+}
+
+// ProductMessage gets the product back from the descriptor. Without the annotations of the CLI,
+// e.g. in provenance made by other tools, the id is the strongest digest and the others are aliases.
+func (d ResourceDescriptor) ProductMessage() (ProductMessage, error) {
+	message := ProductMessage{
+		Name: d.Name,
+		Id:   d.Annotations[annotationArtefactID],
+		Type: ArtefactType(d.Annotations[annotationArtefactType]),
+	}
+	if strings.HasPrefix(d.URI, "pkg:") {
+		message.Purl = d.URI
+	}
+
+	for algorithm, digest := range d.Digest {
+		switch {
+		case algorithm == "gitCommit":
+			if message.Id == "" {
+				message.Id = digest
+				message.Type = ArtefactTypeGit
+			}
+		case isDigestAlgorithm(algorithm):
+			if message.Digests == nil {
+				message.Digests = map[string]string{}
+			}
+			message.Digests[algorithm] = digest
+		}
+	}
+	if message.Id == "" {
+		for _, algorithm := range []string{"sha1", "sha256", "sha512"} {
+			if digest := message.Digests[algorithm]; digest != "" {
+				message.Id = digest
+				if algorithm != "sha1" {
+					message.Id = algorithm + ":" + digest
+				}
+				break
+			}
+		}
+	}
+	if message.Id == "" {
+		message.Id = message.Purl
+	}
+	for algorithm, digest := range message.Digests {
+		// An image id is its digest already
+		if algorithm+":"+digest == message.Id {
+			delete(message.Digests, algorithm)
+		}
+	}
+
+	if message.Id == "" {
+		return message, fmt.Errorf("resource '%s' has no supported digest", d.Name)
+	}
+	if message.Type == "" {
+		message.Type = ArtefactTypeBin
+	}
+	return message, nil
+}
+
+func isDigestAlgorithm(algorithm string) bool {
+	for _, a := range DigestAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// NewProvenance makes the SLSA provenance of the product of the origin payload, its origins are the resolved dependencies
+func NewProvenance(body OriginMessageBody) Statement {
+	statement := Statement{
+		Type:          StatementType,
+		Subject:       []ResourceDescriptor{body.Product.Descriptor()},
+		PredicateType: ProvenancePredicate,
+	}
+
+	definition := &statement.Predicate.BuildDefinition
+	definition.BuildType = ProvenanceBuildType
+	definition.ExternalParameters = map[string]interface{}{"method": body.ProductionMethod}
+	if body.CI != nil {
+		definition.ExternalParameters["ci"] = body.CI
+		statement.Predicate.RunDetails.Metadata.InvocationID = body.CI.URL
+	}
+	for _, origin := range body.Origins {
+		definition.ResolvedDependencies = append(definition.ResolvedDependencies, origin.Descriptor())
+	}
+	statement.Predicate.RunDetails.Builder.ID = ProvenanceBuilderID
+
+	return statement
+}
+
+// OriginMessages turns provenance into origin payloads, one for each subject, with the resolved dependencies as origins.
+// The production method is the one of the CLI provenance, other build types are taken for compilation.
+func (s Statement) OriginMessages() ([]OriginMessageBody, error) {
+	if s.Type != StatementType && s.Type != "https://in-toto.io/Statement/v0.1" {
+		return nil, fmt.Errorf("unsupported statement type '%s'", s.Type)
+	}
+	if s.PredicateType != ProvenancePredicate {
+		return nil, fmt.Errorf("unsupported predicate type '%s'", s.PredicateType)
+	}
+	if len(s.Subject) == 0 {
+		return nil, fmt.Errorf("statement has no subject")
+	}
+
+	definition := s.Predicate.BuildDefinition
+	method := ProductionMethodDefault
+	if m, ok := definition.ExternalParameters["method"].(string); ok && definition.BuildType == ProvenanceBuildType {
+		if !IsValidProductionMethod(ProductionMethod(m)) {
+			return nil, fmt.Errorf("invalid method '%s'", m)
+		}
+		method = ProductionMethod(m)
+	}
+
+	var ci *CIContext
+	if value, ok := definition.ExternalParameters["ci"]; ok && definition.BuildType == ProvenanceBuildType {
+		content, _ := json.Marshal(value)
+		ci = &CIContext{}
+		if err := json.Unmarshal(content, ci); err != nil {
+			return nil, fmt.Errorf("invalid ci parameter: %w", err)
+		}
+	}
+
+	var origins []ProductMessage
+	for _, dependency := range definition.ResolvedDependencies {
+		origin, err := dependency.ProductMessage()
+		if err != nil {
+			return nil, err
+		}
+		origins = append(origins, origin)
+	}
+
+	var bodies []OriginMessageBody
+	for _, subject := range s.Subject {
+		product, err := subject.ProductMessage()
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, OriginMessageBody{CI: ci, Product: product, Origins: origins, ProductionMethod: method})
+	}
+	return bodies, nil
+}
+
+// NewEnvelope wraps the statement, signed with the key unless nil
+func NewEnvelope(statement Statement, key ed25519.PrivateKey) (Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to marshal statement: %w", err)
+	}
+	return Seal(EnvelopePayloadType, payload, key), nil
+}
+
+// Statement decodes the payload of the envelope
+func (e Envelope) Statement() (Statement, error) {
+	var statement Statement
+	payload, err := e.Open(EnvelopePayloadType)
+	if err != nil {
+		return statement, err
+	}
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return statement, fmt.Errorf("invalid statement: %w", err)
+	}
+	return statement, nil
+}
@@ -0,0 +1,244 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PackageURL identifies a software package across ecosystems, see https://github.com/package-url/purl-spec
+type PackageURL struct {
+	Type       string
+	Namespace  string // Slash separated, e.g. "@types" for npm or "github.com/pkg" for golang
+	Name       string
+	Version    string
+	Qualifiers map[string]string
+	Subpath    string
+}
+
+var (
+	purlTypePattern         = regexp.MustCompile(`^[a-z.+-][a-z0-9.+-]*$`)
+	purlQualifierKeyPattern = regexp.MustCompile(`^[a-z.\-_][a-z0-9.\-_]*$`)
+	pythonNameSeparators    = regexp.MustCompile(`[-_.]+`)
+)
+
+// ParsePurl parses and normalizes a package URL, so the same package reported by different tools compares equal
+func ParsePurl(s string) (PackageURL, error) {
+	var p PackageURL
+
+	rest, ok := strings.CutPrefix(s, "pkg:")
+	if !ok {
+		return p, errors.New("purl must start with 'pkg:'")
+	}
+
+	if i := strings.LastIndex(rest, "#"); i >= 0 {
+		var segments []string
+		for _, segment := range strings.Split(rest[i+1:], "/") {
+			if segment, err := url.PathUnescape(segment); err != nil {
+				return p, fmt.Errorf("invalid purl subpath: %w", err)
+			} else if segment != "" && segment != "." && segment != ".." {
+				segments = append(segments, segment)
+			}
+		}
+		p.Subpath = strings.Join(segments, "/")
+		rest = rest[:i]
+	}
+
+	if i := strings.LastIndex(rest, "?"); i >= 0 {
+		for _, pair := range strings.Split(rest[i+1:], "&") {
+			key, value, _ := strings.Cut(pair, "=")
+			key = strings.ToLower(key)
+			value, err := url.PathUnescape(value)
+			if err != nil {
+				return p, fmt.Errorf("invalid purl qualifier '%s': %w", key, err)
+			}
+			if value == "" {
+				continue
+			}
+			if !purlQualifierKeyPattern.MatchString(key) {
+				return p, fmt.Errorf("invalid purl qualifier '%s'", key)
+			}
+			if p.Qualifiers == nil {
+				p.Qualifiers = map[string]string{}
+			}
+			p.Qualifiers[key] = value
+		}
+		rest = rest[:i]
+	}
+
+	rest = strings.Trim(rest, "/")
+	typ, rest, ok := strings.Cut(rest, "/")
+	p.Type = strings.ToLower(typ)
+	if !ok || !purlTypePattern.MatchString(p.Type) {
+		return p, errors.New("invalid purl type")
+	}
+
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		version, err := url.PathUnescape(rest[i+1:])
+		if err != nil {
+			return p, fmt.Errorf("invalid purl version: %w", err)
+		}
+		p.Version = version
+		rest = rest[:i]
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(rest, "/") {
+		segment, err := url.PathUnescape(segment)
+		if err != nil {
+			return p, fmt.Errorf("invalid purl name: %w", err)
+		}
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return p, errors.New("purl name is required")
+	}
+	p.Name = segments[len(segments)-1]
+	p.Namespace = strings.Join(segments[:len(segments)-1], "/")
+
+	// Type specific rules of the specification
+	switch p.Type {
+	case "bitbucket", "github", "composer", "hex", "npm":
+		p.Namespace = strings.ToLower(p.Namespace)
+		p.Name = strings.ToLower(p.Name)
+	case "pypi":
+		// PEP 503, so that Django_Rest and django.rest are the same project
+		p.Name = pythonNameSeparators.ReplaceAllString(strings.ToLower(p.Name), "-")
+	case "maven":
+		if p.Namespace == "" {
+			return p, errors.New("maven purl requires a namespace")
+		}
+	}
+
+	return p, nil
+}
+
+// String renders the canonical form, qualifiers sorted by key
+func (p PackageURL) String() string {
+	s := p.Base()
+	if len(p.Qualifiers) > 0 {
+		var keys []string
+		for k := range p.Qualifiers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var pairs []string
+		for _, k := range keys {
+			pairs = append(pairs, k+"="+purlEscape(p.Qualifiers[k], ":"))
+		}
+		s += "?" + strings.Join(pairs, "&")
+	}
+
+	if p.Subpath != "" {
+		var segments []string
+		for _, segment := range strings.Split(p.Subpath, "/") {
+			segments = append(segments, purlEscape(segment, ":"))
+		}
+		s += "#" + strings.Join(segments, "/")
+	}
+
+	return s
+}
+
+// Base renders the package and version without qualifiers and subpath, e.g. to match a package whatever its distro or arch
+func (p PackageURL) Base() string {
+	s := "pkg:" + p.Type + "/"
+	if p.Namespace != "" {
+		for _, segment := range strings.Split(p.Namespace, "/") {
+			s += purlEscape(segment, ":") + "/"
+		}
+	}
+	s += purlEscape(p.Name, ":")
+	if p.Version != "" {
+		s += "@" + purlEscape(p.Version, ":")
+	}
+	return s
+}
+
+// purlEscape percent-encodes everything but unreserved characters and the ones kept
+func purlEscape(s string, keep string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(".-_~", c) >= 0 || strings.IndexByte(keep, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// NormalizeCPE validates a CPE name and returns it in the CPE 2.3 formatted string binding, lowercased.
+// CPE 2.2 URIs such as cpe:/a:apache:log4j:2.14.1 are converted.
+func NormalizeCPE(s string) (string, error) {
+	const components = 11 // part, vendor, product, version, update, edition, language, sw_edition, target_sw, target_hw, other
+
+	var fields []string
+	switch {
+	case strings.HasPrefix(s, "cpe:2.3:"):
+		fields = splitCPE(s[len("cpe:2.3:"):])
+		if len(fields) != components {
+			return "", fmt.Errorf("CPE 2.3 name must have %d components, got %d", components, len(fields))
+		}
+	case strings.HasPrefix(s, "cpe:/"):
+		fields = strings.Split(s[len("cpe:/"):], ":")
+		if len(fields) > 7 {
+			return "", errors.New("CPE 2.2 URI has too many components")
+		}
+		for i, f := range fields {
+			if f, err := url.PathUnescape(f); err != nil {
+				return "", fmt.Errorf("invalid CPE: %w", err)
+			} else if f == "" {
+				fields[i] = "*"
+			} else {
+				fields[i] = f
+			}
+		}
+		for len(fields) < components {
+			fields = append(fields, "*")
+		}
+	default:
+		return "", errors.New("CPE must start with 'cpe:2.3:' or 'cpe:/'")
+	}
+
+	for i := range fields {
+		fields[i] = strings.ToLower(fields[i])
+		if fields[i] == "" {
+			return "", fmt.Errorf("CPE component %d is empty", i+1)
+		}
+	}
+	switch fields[0] {
+	case "a", "o", "h", "*":
+	default:
+		return "", fmt.Errorf("invalid CPE part '%s'", fields[0])
+	}
+
+	return "cpe:2.3:" + strings.Join(fields, ":"), nil
+}
+
+// splitCPE splits on colons not escaped by a backslash
+func splitCPE(s string) []string {
+	var fields []string
+	var current strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			current.WriteByte(s[i])
+			current.WriteByte(s[i+1])
+			i++
+		case s[i] == ':':
+			fields = append(fields, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(s[i])
+		}
+	}
+	return append(fields, current.String())
+}
@@ -0,0 +1,136 @@
+package shared
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Payload types of the collect and origin payloads when they are sent signed, in a DSSE envelope
+const (
+	CollectPayloadType = "application/vnd.aspm.collect+json"
+	OriginPayloadType  = "application/vnd.aspm.origin+json"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// KeyID names the public key by its SHA-256 fingerprint, the way ssh-keygen -l does
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// Seal wraps the payload in a DSSE envelope of the type, signed with the key unless nil. The signature covers the payload
+// exactly as it is sent, so fields the receiver does not know are still signed and kept as they are.
+func Seal(payloadType string, payload []byte, key ed25519.PrivateKey) Envelope {
+	envelope := Envelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []EnvelopeSignature{},
+	}
+	if key != nil {
+		envelope.Signatures = append(envelope.Signatures, EnvelopeSignature{
+			KeyID: KeyID(key.Public().(ed25519.PublicKey)),
+			Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(key, pae(payloadType, payload))),
+		})
+	}
+	return envelope
+}
+
+// Open returns the payload of the envelope, it must be of the type
+func (e Envelope) Open(payloadType string) ([]byte, error) {
+	if e.PayloadType != payloadType {
+		return nil, fmt.Errorf("unsupported payload type '%s'", e.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	return payload, nil
+}
+
+// VerifyEnvelope checks the signature of the key id against the public key
+func VerifyEnvelope(e Envelope, keyID string, key ed25519.PublicKey) error {
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	for _, signature := range e.Signatures {
+		if signature.KeyID != keyID {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err == nil && ed25519.Verify(key, pae(e.PayloadType, payload), sig) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// pae is the DSSE pre-authentication encoding, what is actually signed
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// ParsePrivateKey reads an ed25519 private key in PKCS #8 PEM, e.g. made by openssl genpkey -algorithm ed25519
+func ParsePrivateKey(content []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an ed25519 key")
+	}
+	return private, nil
+}
+
+// ParsePublicKey reads an ed25519 public key in PKIX PEM or the raw key in base64
+func ParsePublicKey(text string) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode([]byte(text)); block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("no PEM public key found")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an ed25519 key")
+		}
+		return public, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("not an ed25519 key")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// MarshalPrivateKey and MarshalPublicKey write the keys in the PEM forms the parsers read
+func MarshalPrivateKey(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func MarshalPublicKey(key ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}